@@ -0,0 +1,669 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"reflect"
+	"socks5server-demo/socks5"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// fileConfig 配置，优先级：默认值 < 配置文件（JSON） < 环境变量 < 命令行中显式设置的标志
+type fileConfig struct {
+	// Server true 为服务端，false 为本地客户端
+	Server     bool             `json:"server"`
+	Listen     listenConfig     `json:"listen"`
+	Upstream   upstreamConfig   `json:"upstream"`
+	Auth       authConfig       `json:"auth"`
+	TLS        serverTLSConfig  `json:"tls"`
+	Rules      rulesConfig      `json:"rules"`
+	Limits     limitsConfig     `json:"limits"`
+	Accounting accountingConfig `json:"accounting"`
+	Timeouts   timeoutsConfig   `json:"timeouts"`
+	Admin      adminConfig      `json:"admin"`
+	Metrics    metricsConfig    `json:"metrics"`
+	Log        logConfig        `json:"log"`
+}
+
+type listenConfig struct {
+	// Address 监听的地址，为空时服务端监听所有地址，本地客户端监听 127.0.0.1
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// Socks4 同一端口同时支持 socks4 和 socks4a
+	Socks4 bool `json:"socks4"`
+}
+
+// upstreamConfig 本地客户端连接的远程服务端
+type upstreamConfig struct {
+	Addr string            `json:"addr"`
+	Port int               `json:"port"`
+	TLS  upstreamTLSConfig `json:"tls"`
+}
+
+type upstreamTLSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CA         string `json:"ca"`
+	ServerName string `json:"serverName"`
+	Insecure   bool   `json:"insecure"`
+	Cert       string `json:"cert"`
+	Key        string `json:"key"`
+}
+
+type authConfig struct {
+	// Username、Passwd 服务端的用户名密码，本地客户端连接远程服务端时使用
+	Username       string   `json:"username"`
+	Passwd         string   `json:"passwd"`
+	UserFile       string   `json:"userFile"`
+	NoAuthNetworks []string `json:"noAuthNetworks"`
+	MaxFailures    int      `json:"maxFailures"`
+	Lockout        duration `json:"lockout"`
+}
+
+type serverTLSConfig struct {
+	Cert               string `json:"cert"`
+	Key                string `json:"key"`
+	ClientCA           string `json:"clientCA"`
+	ClientCertOptional bool   `json:"clientCertOptional"`
+}
+
+type rulesConfig struct {
+	ACLFile string `json:"aclFile"`
+	// ACL 直接写在配置文件中的访问控制规则，与 ACLFile 二选一
+	ACL          *socks5.ACL `json:"acl"`
+	AllowPrivate bool        `json:"allowPrivate"`
+	AllowClients []string    `json:"allowClients"`
+	DenyClients  []string    `json:"denyClients"`
+}
+
+type limitsConfig struct {
+	GlobalRate       int64    `json:"globalRate"`
+	UserRate         int64    `json:"userRate"`
+	ConnRate         int64    `json:"connRate"`
+	MaxConns         int      `json:"maxConns"`
+	MaxConnsPerUser  int      `json:"maxConnsPerUser"`
+	MaxConnsPerIP    int      `json:"maxConnsPerIP"`
+	ConnQueueTimeout duration `json:"connQueueTimeout"`
+}
+
+type accountingConfig struct {
+	File         string `json:"file"`
+	DailyQuota   int64  `json:"dailyQuota"`
+	MonthlyQuota int64  `json:"monthlyQuota"`
+	CutSessions  bool   `json:"cutSessions"`
+}
+
+type timeoutsConfig struct {
+	// Handshake 协商、请求和连接目标的超时时间
+	Handshake duration `json:"handshake"`
+	// Bind BIND 等待对端连接的超时时间，0 时使用 Handshake
+	Bind     duration `json:"bind"`
+	Shutdown duration `json:"shutdown"`
+}
+
+type adminConfig struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token"`
+}
+
+type metricsConfig struct {
+	Addr string `json:"addr"`
+}
+
+type logConfig struct {
+	Level               string   `json:"level"`
+	AccessLog           string   `json:"accessLog"`
+	AccessLogFormat     string   `json:"accessLogFormat"`
+	AccessLogMaxSize    int64    `json:"accessLogMaxSize"`
+	AccessLogMaxAge     duration `json:"accessLogMaxAge"`
+	AccessLogMaxBackups int      `json:"accessLogMaxBackups"`
+}
+
+// defaultConfig 默认配置，与命令行标志的默认值一致
+func defaultConfig() *fileConfig {
+	return &fileConfig{
+		Listen:   listenConfig{Port: 8080},
+		Upstream: upstreamConfig{Addr: "127.0.0.1", Port: 10808},
+		Auth:     authConfig{MaxFailures: 5, Lockout: duration(15 * time.Minute)},
+		Timeouts: timeoutsConfig{Handshake: duration(30 * time.Second), Shutdown: duration(30 * time.Second)},
+		Log: logConfig{
+			Level:               "INFO",
+			AccessLogFormat:     socks5.AccessLogJSON,
+			AccessLogMaxSize:    100 << 20,
+			AccessLogMaxAge:     duration(24 * time.Hour),
+			AccessLogMaxBackups: 7,
+		},
+	}
+}
+
+// registerFlags 注册命令行标志，解析后直接写入 c
+func registerFlags(flags *flag.FlagSet, c *fileConfig) {
+	flags.BoolVar(&c.Server, "server", c.Server, "pls input port")
+	flags.IntVar(&c.Listen.Port, "port", c.Listen.Port, "pls input port")
+	flags.StringVar(&c.Auth.Username, "username", c.Auth.Username, "pls input username")
+	flags.StringVar(&c.Auth.Passwd, "passwd", c.Auth.Passwd, "pls input passwd")
+	flags.StringVar(&c.Upstream.Addr, "remoteAddr", c.Upstream.Addr, "pls input remoteAddr")
+	flags.IntVar(&c.Upstream.Port, "remotePort", c.Upstream.Port, "pls input remotePort")
+	flags.StringVar(&c.Log.Level, "logLevel", c.Log.Level, "pls input remotePort")
+	flags.BoolVar(&c.Listen.Socks4, "socks4", c.Listen.Socks4, "enable socks4 and socks4a on the same port")
+	// 服务端 TLS
+	flags.StringVar(&c.TLS.Cert, "tlsCert", c.TLS.Cert, "server: tls cert file (PEM), enable tls when set")
+	flags.StringVar(&c.TLS.Key, "tlsKey", c.TLS.Key, "server: tls key file (PEM)")
+	flags.StringVar(&c.TLS.ClientCA, "tlsClientCA", c.TLS.ClientCA, "server: require client certs signed by this CA file, cert CN/SAN is used as the user")
+	flags.BoolVar(&c.TLS.ClientCertOptional, "tlsClientCertOptional", c.TLS.ClientCertOptional, "server: clients without cert fall back to username/passwd")
+	// 本地客户端 TLS
+	flags.BoolVar(&c.Upstream.TLS.Enabled, "tls", c.Upstream.TLS.Enabled, "client: connect to remote server with tls")
+	flags.StringVar(&c.Upstream.TLS.CA, "tlsCA", c.Upstream.TLS.CA, "client: only trust server certs signed by this CA file")
+	flags.StringVar(&c.Upstream.TLS.ServerName, "tlsServerName", c.Upstream.TLS.ServerName, "client: tls server name (SNI), default remoteAddr")
+	flags.BoolVar(&c.Upstream.TLS.Insecure, "tlsInsecure", c.Upstream.TLS.Insecure, "client: skip server cert verification, for testing only")
+	flags.StringVar(&c.Upstream.TLS.Cert, "tlsClientCert", c.Upstream.TLS.Cert, "client: client cert file (PEM) used to authenticate to remote server")
+	flags.StringVar(&c.Upstream.TLS.Key, "tlsClientKey", c.Upstream.TLS.Key, "client: client key file (PEM)")
+	flags.StringVar(&c.Auth.UserFile, "userFile", c.Auth.UserFile, "server: htpasswd file (bcrypt/argon2id/{SHA}/{SSHA}), reloaded on change, see `socks5Server user`")
+	flags.IntVar(&c.Auth.MaxFailures, "authMaxFailures", c.Auth.MaxFailures, "server: lock out a source ip or username after this many consecutive auth failures, 0 disables")
+	flags.DurationVar((*time.Duration)(&c.Auth.Lockout), "authLockout", time.Duration(c.Auth.Lockout), "server: auth lockout duration")
+	flags.StringVar(&c.Admin.Addr, "adminAddr", c.Admin.Addr, "server: admin http listen address, e.g. 127.0.0.1:9090")
+	flags.StringVar(&c.Admin.Token, "adminToken", c.Admin.Token, "server: bearer token for the admin api, required when adminAddr is set")
+	flags.BoolVar(&c.Rules.AllowPrivate, "allowPrivate", c.Rules.AllowPrivate, "server: allow proxying to private, loopback, link-local, CGNAT, multicast and ULA destinations")
+	flags.StringVar(&c.Rules.ACLFile, "aclFile", c.Rules.ACLFile, "server: destination access control rules file (JSON)")
+	flags.Var((*commaList)(&c.Rules.AllowClients), "allowClients", "server: comma separated CIDRs, only accept clients from these networks")
+	flags.Var((*commaList)(&c.Rules.DenyClients), "denyClients", "server: comma separated CIDRs, reject clients from these networks")
+	flags.Int64Var(&c.Limits.GlobalRate, "globalRate", c.Limits.GlobalRate, "server: total bandwidth limit in bytes/s for each direction, 0 unlimited")
+	flags.Int64Var(&c.Limits.UserRate, "userRate", c.Limits.UserRate, "server: per user bandwidth limit in bytes/s for each direction, 0 unlimited")
+	flags.Int64Var(&c.Limits.ConnRate, "connRate", c.Limits.ConnRate, "server: per connection bandwidth limit in bytes/s for each direction, 0 unlimited")
+	flags.StringVar(&c.Accounting.File, "accountingFile", c.Accounting.File, "server: per user traffic accounting file, totals survive restarts")
+	flags.Int64Var(&c.Accounting.DailyQuota, "dailyQuota", c.Accounting.DailyQuota, "server: per user daily traffic quota in bytes (upload+download), 0 unlimited")
+	flags.Int64Var(&c.Accounting.MonthlyQuota, "monthlyQuota", c.Accounting.MonthlyQuota, "server: per user monthly traffic quota in bytes (upload+download), 0 unlimited")
+	flags.BoolVar(&c.Accounting.CutSessions, "quotaCutSessions", c.Accounting.CutSessions, "server: also cut live sessions of users over quota")
+	flags.IntVar(&c.Limits.MaxConns, "maxConns", c.Limits.MaxConns, "max concurrent connections, 0 unlimited")
+	flags.IntVar(&c.Limits.MaxConnsPerUser, "maxConnsPerUser", c.Limits.MaxConnsPerUser, "server: max concurrent connections per authenticated user, 0 unlimited")
+	flags.IntVar(&c.Limits.MaxConnsPerIP, "maxConnsPerIP", c.Limits.MaxConnsPerIP, "max concurrent connections per client ip, 0 unlimited")
+	flags.DurationVar((*time.Duration)(&c.Limits.ConnQueueTimeout), "connQueueTimeout", time.Duration(c.Limits.ConnQueueTimeout), "wait this long for a free slot when the global connection limit is hit, 0 refuses immediately")
+	flags.StringVar(&c.Metrics.Addr, "metricsAddr", c.Metrics.Addr, "prometheus metrics http listen address, served at /metrics, e.g. 127.0.0.1:9100")
+	flags.StringVar(&c.Log.AccessLog, "accessLog", c.Log.AccessLog, "server: access log file, one record per tunnel, \"-\" for stdout")
+	flags.StringVar(&c.Log.AccessLogFormat, "accessLogFormat", c.Log.AccessLogFormat, "server: access log format, json or text")
+	flags.Int64Var(&c.Log.AccessLogMaxSize, "accessLogMaxSize", c.Log.AccessLogMaxSize, "server: rotate the access log when it exceeds this many bytes, 0 disables")
+	flags.DurationVar((*time.Duration)(&c.Log.AccessLogMaxAge), "accessLogMaxAge", time.Duration(c.Log.AccessLogMaxAge), "server: rotate the access log after this long, 0 disables")
+	flags.IntVar(&c.Log.AccessLogMaxBackups, "accessLogMaxBackups", c.Log.AccessLogMaxBackups, "server: number of rotated access logs to keep, 0 keeps all")
+	flags.DurationVar((*time.Duration)(&c.Timeouts.Shutdown), "shutdownTimeout", time.Duration(c.Timeouts.Shutdown), "on SIGINT/SIGTERM wait this long for in-flight tunnels before force closing them")
+	flags.Var((*commaList)(&c.Auth.NoAuthNetworks), "noAuthNetworks", "server: comma separated CIDRs that may skip username/passwd, e.g. 127.0.0.0/8,::1/128")
+}
+
+// loadConfig 依次应用配置文件（file 为空时跳过）、环境变量和 explicit 中显式设置的标志，并校验
+func loadConfig(file string, lookupEnv func(string) (string, bool), explicit *flag.FlagSet) (*fileConfig, error) {
+	c := defaultConfig()
+	var errs configErrors
+	if file != "" {
+		errs = append(errs, c.loadFile(file)...)
+	}
+	errs = append(errs, c.applyEnv(lookupEnv)...)
+	if explicit != nil {
+		overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+		registerFlags(overrides, c)
+		explicit.Visit(func(f *flag.Flag) {
+			if overrides.Lookup(f.Name) == nil {
+				return
+			}
+			if err := overrides.Set(f.Name, f.Value.String()); err != nil {
+				errs = append(errs, fieldError{Path: "-" + f.Name, Message: err.Error()})
+			}
+		})
+	}
+	// 解析失败时字段可能不完整，不再校验
+	if len(errs) == 0 {
+		errs = c.validate()
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// fieldError 配置中某个字段的错误，Path 如 listen.port、rules.allowClients[1]
+type fieldError struct {
+	Path string
+	// Line 配置文件中的行号，未知时为 0
+	Line    int
+	Message string
+}
+
+func (e fieldError) Error() string {
+	message := e.Message
+	if e.Path != "" {
+		message = e.Path + ": " + message
+	}
+	if e.Line > 0 {
+		message = fmt.Sprintf("line %d: %s", e.Line, message)
+	}
+	return message
+}
+
+// configErrors 配置中的所有错误，每行一个
+type configErrors []fieldError
+
+func (e configErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadFile 从 JSON 文件加载配置，未知字段和类型错误都会指出字段路径
+func (c *fileConfig) loadFile(file string) configErrors {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return configErrors{{Message: err.Error()}}
+	}
+	var raw any
+	if err := json.Unmarshal(content, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return configErrors{{Line: lineAt(content, syntaxErr.Offset), Message: syntaxErr.Error()}}
+		}
+		return configErrors{{Message: err.Error()}}
+	}
+	if errs := checkFields(raw, reflect.TypeOf(c), ""); len(errs) > 0 {
+		return errs
+	}
+	if err := json.Unmarshal(content, c); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			line := 0
+			if typeErr.Offset > 0 {
+				line = lineAt(content, typeErr.Offset)
+			}
+			return configErrors{{Path: typeErr.Field, Line: line, Message: fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeName(typeErr.Type))}}
+		}
+		return configErrors{{Message: err.Error()}}
+	}
+	return nil
+}
+
+// lineAt offset 所在的行号
+func lineAt(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return strings.Count(string(content[:offset]), "\n") + 1
+}
+
+func typeName(t reflect.Type) string {
+	if t == reflect.TypeOf(duration(0)) {
+		return `duration (e.g. "30s")`
+	}
+	return t.String()
+}
+
+// checkFields 检查配置文件中不存在于 t 的字段（与 encoding/json 一样字段名不区分大小写）和时长的格式，
+// 自定义类型解析失败时 encoding/json 不会给出字段路径，所以在这里检查
+func checkFields(raw any, t reflect.Type, path string) configErrors {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var errs configErrors
+	if t == reflect.TypeOf(duration(0)) {
+		if value, ok := raw.(string); !ok {
+			errs = append(errs, fieldError{Path: path, Message: fmt.Sprintf("cannot use %v as %s", raw, typeName(t))})
+		} else if _, err := time.ParseDuration(value); err != nil {
+			errs = append(errs, fieldError{Path: path, Message: fmt.Sprintf("cannot use %q as %s", value, typeName(t))})
+		}
+		return errs
+	}
+	switch value := raw.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := jsonField(t, key)
+			if !ok {
+				errs = append(errs, fieldError{Path: joinPath(path, key), Message: "unknown field"})
+				continue
+			}
+			errs = append(errs, checkFields(value[key], field.Type, joinPath(path, key))...)
+		}
+	case []any:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range value {
+			errs = append(errs, checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+// jsonField 按 json 标签查找字段
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tagName := jsonName(field); tagName != "" && strings.EqualFold(tagName, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonName 字段的 json 名称，未导出或忽略的字段为空
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// applyEnv 用环境变量覆盖配置，变量名为 SOCKS5_ 加上字段路径，如 listen.port 为 SOCKS5_LISTEN_PORT，
+// auth.userFile 为 SOCKS5_AUTH_USER_FILE；列表以逗号分隔，rules.acl 不支持
+func (c *fileConfig) applyEnv(lookupEnv func(string) (string, bool)) configErrors {
+	var errs configErrors
+	walkFields(reflect.ValueOf(c).Elem(), "", func(path string, field reflect.Value) {
+		name := envName(path)
+		value, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fieldError{Path: path, Message: fmt.Sprintf("env %s: %s", name, err)})
+		}
+	})
+	return errs
+}
+
+// walkFields 遍历可以用字符串设置的字段
+func walkFields(v reflect.Value, path string, visit func(path string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		name := jsonName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			walkFields(field, joinPath(path, name), visit)
+		case field.Kind() != reflect.Pointer:
+			visit(joinPath(path, name), field)
+		}
+	}
+}
+
+// envName 字段路径对应的环境变量名
+func envName(path string) string {
+	var name strings.Builder
+	name.WriteString("SOCKS5")
+	for _, part := range strings.Split(path, ".") {
+		name.WriteByte('_')
+		var prev rune
+		for _, r := range part {
+			if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+			prev = r
+		}
+	}
+	return name.String()
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// validate 校验配置，返回所有错误
+func (c *fileConfig) validate() configErrors {
+	var errs configErrors
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, fieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if c.Listen.Port < 1 || c.Listen.Port > 65535 {
+		fail("listen.port", "must be between 1 and 65535, got %d", c.Listen.Port)
+	}
+	if !c.Server {
+		if c.Upstream.Addr == "" {
+			fail("upstream.addr", "required in client mode")
+		}
+		if c.Upstream.Port < 1 || c.Upstream.Port > 65535 {
+			fail("upstream.port", "must be between 1 and 65535, got %d", c.Upstream.Port)
+		}
+		if (c.Upstream.TLS.Cert == "") != (c.Upstream.TLS.Key == "") {
+			fail("upstream.tls.key", "upstream.tls.cert and upstream.tls.key must be set together")
+		}
+		for path, file := range map[string]string{"upstream.tls.ca": c.Upstream.TLS.CA, "upstream.tls.cert": c.Upstream.TLS.Cert, "upstream.tls.key": c.Upstream.TLS.Key} {
+			checkFile(fail, path, file)
+		}
+	}
+
+	if c.Auth.MaxFailures < 0 {
+		fail("auth.maxFailures", "must not be negative")
+	}
+	if c.Auth.MaxFailures > 0 && c.Auth.Lockout <= 0 {
+		fail("auth.lockout", "must be positive when auth.maxFailures is set")
+	}
+	checkFile(fail, "auth.userFile", c.Auth.UserFile)
+	checkCIDRs(fail, "auth.noAuthNetworks", c.Auth.NoAuthNetworks)
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		fail("tls.key", "tls.cert and tls.key must be set together")
+	}
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		fail("tls.clientCA", "requires tls.cert")
+	}
+	if c.TLS.ClientCertOptional && c.TLS.ClientCA == "" {
+		fail("tls.clientCertOptional", "requires tls.clientCA")
+	}
+	for path, file := range map[string]string{"tls.cert": c.TLS.Cert, "tls.key": c.TLS.Key, "tls.clientCA": c.TLS.ClientCA} {
+		checkFile(fail, path, file)
+	}
+
+	if c.Rules.ACLFile != "" && c.Rules.ACL != nil {
+		fail("rules.acl", "rules.aclFile and rules.acl are mutually exclusive")
+	} else if c.Rules.ACLFile != "" {
+		if _, err := socks5.LoadACL(c.Rules.ACLFile); err != nil {
+			fail("rules.aclFile", "%s", err)
+		}
+	} else if c.Rules.ACL != nil {
+		if err := c.Rules.ACL.Compile(); err != nil {
+			fail("rules.acl", "%s", err)
+		}
+	}
+	checkCIDRs(fail, "rules.allowClients", c.Rules.AllowClients)
+	checkCIDRs(fail, "rules.denyClients", c.Rules.DenyClients)
+
+	for path, value := range map[string]int64{
+		"limits.globalRate":       c.Limits.GlobalRate,
+		"limits.userRate":         c.Limits.UserRate,
+		"limits.connRate":         c.Limits.ConnRate,
+		"limits.maxConns":         int64(c.Limits.MaxConns),
+		"limits.maxConnsPerUser":  int64(c.Limits.MaxConnsPerUser),
+		"limits.maxConnsPerIP":    int64(c.Limits.MaxConnsPerIP),
+		"limits.connQueueTimeout": int64(c.Limits.ConnQueueTimeout),
+		"accounting.dailyQuota":   c.Accounting.DailyQuota,
+		"accounting.monthlyQuota": c.Accounting.MonthlyQuota,
+		"timeouts.handshake":      int64(c.Timeouts.Handshake),
+		"timeouts.bind":           int64(c.Timeouts.Bind),
+		"timeouts.shutdown":       int64(c.Timeouts.Shutdown),
+		"log.accessLogMaxSize":    c.Log.AccessLogMaxSize,
+		"log.accessLogMaxAge":     int64(c.Log.AccessLogMaxAge),
+		"log.accessLogMaxBackups": int64(c.Log.AccessLogMaxBackups),
+	} {
+		if value < 0 {
+			fail(path, "must not be negative")
+		}
+	}
+	if c.Accounting.File == "" {
+		if c.Accounting.DailyQuota > 0 {
+			fail("accounting.dailyQuota", "requires accounting.file")
+		}
+		if c.Accounting.MonthlyQuota > 0 {
+			fail("accounting.monthlyQuota", "requires accounting.file")
+		}
+	}
+	if c.Admin.Token != "" && c.Admin.Addr == "" {
+		fail("admin.token", "requires admin.addr")
+	}
+	if c.Admin.Addr != "" && c.Admin.Token == "" {
+		fail("admin.addr", "requires admin.token")
+	}
+
+	switch strings.ToUpper(c.Log.Level) {
+	case "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		fail("log.level", "must be one of DEBUG, INFO, WARN, ERROR, got %q", c.Log.Level)
+	}
+	if c.Log.AccessLogFormat != socks5.AccessLogJSON && c.Log.AccessLogFormat != socks5.AccessLogText {
+		fail("log.accessLogFormat", "must be json or text, got %q", c.Log.AccessLogFormat)
+	}
+
+	// map 遍历顺序不固定，按路径排序使输出稳定
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// checkFile 文件已设置时必须存在
+func checkFile(fail func(path, format string, args ...any), path, file string) {
+	if file == "" {
+		return
+	}
+	if _, err := os.Stat(file); err != nil {
+		fail(path, "%s", err)
+	}
+}
+
+func checkCIDRs(fail func(path, format string, args ...any), path string, values []string) {
+	for i, value := range values {
+		if _, err := netip.ParsePrefix(value); err != nil {
+			fail(fmt.Sprintf("%s[%d]", path, i), "invalid CIDR %q", value)
+		}
+	}
+}
+
+// configCommand 配置子命令：socks5Server config validate [-file=config.json]
+// 应用环境变量后校验，有错误时逐行输出字段路径和原因
+func configCommand(args []string, lookupEnv func(string) (string, bool), stdout io.Writer) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	fileFlag := flags.String("file", "config.json", "config file (JSON)")
+	flags.SetOutput(stdout)
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "usage: socks5Server config validate [-file=config.json]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "validate" {
+		flags.Usage()
+		return fmt.Errorf("unknown config command %q", strings.Join(args, " "))
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if _, err := loadConfig(*fileFlag, lookupEnv, nil); err != nil {
+		var errs configErrors
+		if errors.As(err, &errs) {
+			for _, fieldErr := range errs {
+				fmt.Fprintf(stdout, "%s: %s\n", *fileFlag, fieldErr.Error())
+			}
+			return fmt.Errorf("%s: %d error(s)", *fileFlag, len(errs))
+		}
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: ok\n", *fileFlag)
+	return nil
+}
+
+// duration 配置文件中的时长，格式同 time.ParseDuration，如 "30s"、"15m"
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// commaList 逗号分隔的列表标志
+type commaList []string
+
+func (l *commaList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *commaList) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// splitList 按逗号分割，去掉空白和空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configFileForTest 写入临时配置文件
+func configFileForTest(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile error %s", err)
+	}
+	return file
+}
+
+// envForTest 模拟环境变量
+func envForTest(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	file := configFileForTest(t, `{
+  "server": true,
+  "listen": {"port": 1080, "socks4": true},
+  "auth": {"username": "alice", "passwd": "from-file", "lockout": "1m"},
+  "rules": {"allowClients": ["10.0.0.0/8"], "acl": {"rules": [{"action": "deny", "ports": ["25"]}]}},
+  "limits": {"maxConnsPerIP": 10},
+  "timeouts": {"handshake": "5s"}
+}`)
+	env := envForTest(map[string]string{
+		"SOCKS5_AUTH_PASSWD":             "from-env",
+		"SOCKS5_LIMITS_MAX_CONNS_PER_IP": "20",
+		"SOCKS5_RULES_DENY_CLIENTS":      "192.0.2.0/24, 198.51.100.0/24",
+	})
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerFlags(flags, defaultConfig())
+	if err := flags.Parse([]string{"-port=1081", "-maxConnsPerIP=30", "-authLockout=2m"}); err != nil {
+		t.Fatalf("flags.Parse error %s", err)
+	}
+
+	c, err := loadConfig(file, env, flags)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	// 默认值 < 配置文件 < 环境变量 < 显式设置的标志
+	if !c.Server || !c.Listen.Socks4 || c.Auth.Username != "alice" || c.Upstream.Port != 10808 || c.Auth.MaxFailures != 5 {
+		t.Fatalf("want get file and default values but got %+v", c)
+	}
+	if c.Listen.Port != 1081 || c.Limits.MaxConnsPerIP != 30 || time.Duration(c.Auth.Lockout) != 2*time.Minute {
+		t.Fatalf("want get flag values but got %+v", c)
+	}
+	if c.Auth.Passwd != "from-env" || !reflect.DeepEqual(c.Rules.DenyClients, []string{"192.0.2.0/24", "198.51.100.0/24"}) {
+		t.Fatalf("want get env values but got %+v", c)
+	}
+	if time.Duration(c.Timeouts.Handshake) != 5*time.Second || c.Rules.ACL == nil || len(c.Rules.ACL.Rules) != 1 {
+		t.Fatalf("want get timeouts and acl but got %+v", c)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    []string
+	}{
+		{"syntax", "{\n  \"listen\": {\"port\": 1080,}\n}", nil, []string{"line 2: invalid character '}'"}},
+		{"unknown field", `{"listen": {"prot": 1080}, "rules": {"acl": {"rules": [{"action": "deny", "port": ["25"]}]}}}`, nil,
+			[]string{"listen.prot: unknown field", "rules.acl.rules[0].port: unknown field"}},
+		{"type", "{\n  \"listen\": {\"port\": \"1080\"}\n}", nil, []string{"line 2: listen.port: cannot use string as int"}},
+		{"duration", `{"auth": {"lockout": "15 minutes"}}`, nil, []string{`auth.lockout: cannot use "15 minutes" as duration`}},
+		{"env", `{}`, map[string]string{"SOCKS5_LISTEN_PORT": "http"}, []string{"listen.port: env SOCKS5_LISTEN_PORT: strconv.ParseInt"}},
+		{"validate", `{"server": true, "listen": {"port": 70000}, "tls": {"cert": "a.pem"},
+			"rules": {"denyClients": ["10.0.0.0/8", "10.0.0.1/33"], "acl": {"rules": [{"action": "drop"}]}}, "log": {"level": "trace"}, "admin": {"addr": "127.0.0.1:9090"}}`, nil,
+			[]string{"admin.addr: requires admin.token", "listen.port: must be between 1 and 65535", "log.level: must be one of", "rules.acl: rule[0]: invalid action \"drop\"",
+				"rules.denyClients[1]: invalid CIDR", "tls.key: tls.cert and tls.key must be set together"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(configFileForTest(t, tt.content), envForTest(tt.env), nil)
+			var errs configErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("want get configErrors but got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("want get %q but got\n%s", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	file := configFileForTest(t, `{"server": true, "rules": {"allowClients": ["bad"]}}`)
+	if err := configCommand([]string{"validate", "-file=" + file}, envForTest(nil), &stdout); err == nil {
+		t.Fatalf("want get err but got nil")
+	}
+	if want := file + ": rules.allowClients[0]: invalid CIDR \"bad\"\n"; stdout.String() != want {
+		t.Fatalf("want get %q but got %q", want, stdout.String())
+	}
+
+	stdout.Reset()
+	file = configFileForTest(t, `{"server": true}`)
+	if err := configCommand([]string{"validate", "-file=" + file}, envForTest(nil), &stdout); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if want := file + ": ok\n"; stdout.String() != want {
+		t.Fatalf("want get %q but got %q", want, stdout.String())
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for path, want := range map[string]string{
+		"listen.port":             "SOCKS5_LISTEN_PORT",
+		"auth.userFile":           "SOCKS5_AUTH_USER_FILE",
+		"limits.maxConnsPerIP":    "SOCKS5_LIMITS_MAX_CONNS_PER_IP",
+		"tls.clientCA":            "SOCKS5_TLS_CLIENT_CA",
+		"upstream.tls.enabled":    "SOCKS5_UPSTREAM_TLS_ENABLED",
+		"log.accessLogMaxBackups": "SOCKS5_LOG_ACCESS_LOG_MAX_BACKUPS",
+	} {
+		if got := envName(path); got != want {
+			t.Fatalf("want get %s but got %s", want, got)
+		}
+	}
+}
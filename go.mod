@@ -1,3 +1,10 @@
 module socks5server-demo
 
 go 1.21.0
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/time v0.5.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -1,76 +1,303 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"socks5server-demo/socks5"
 	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run 启动服务直到收到信号，返回进程的退出码；defer（写入流量统计、关闭访问日志）在 run 返回时执行
+func run() int {
+	// 用户管理子命令
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		if err := userCommand(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
 
-	serverBoolFlag := flag.Bool("server", false, "pls input port")
-	portFlag := flag.Int("port", 8080, "pls input port")
-	usernameFlag := flag.String("username", "", "pls input username")
-	passwdFlag := flag.String("passwd", "", "pls input passwd")
-	remoteAddrFlag := flag.String("remoteAddr", "127.0.0.1", "pls input remoteAddr")
-	remotePortFlag := flag.Int("remotePort", 10808, "pls input remotePort")
-	logLevel := flag.String("logLevel", "INFO", "pls input remotePort")
+	// 配置文件子命令
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := configCommand(os.Args[2:], os.LookupEnv, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
 
+	configFlag := flag.String("config", "", "config file (JSON), overridden by SOCKS5_* env and explicitly set flags, see `socks5Server config validate`")
+	registerFlags(flag.CommandLine, defaultConfig())
 	// 解析标志参数
 	flag.Parse()
+	cfg, err := loadConfig(*configFlag, os.LookupEnv, flag.CommandLine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 	// 修改日志级别
-	setSlog(logLevel)
-	port := *portFlag
-	username := *usernameFlag
-	passwd := *passwdFlag
-	isServer := *serverBoolFlag
-	remoteAddr := *remoteAddrFlag
-	remotePort := *remotePortFlag
-	address := ""
+	setSlog(&cfg.Log.Level)
+	port := cfg.Listen.Port
+	username := cfg.Auth.Username
+	passwd := cfg.Auth.Passwd
+	isServer := cfg.Server
+	remoteAddr := cfg.Upstream.Addr
+	remotePort := cfg.Upstream.Port
+	address := cfg.Listen.Address
+	// 收到 SIGINT/SIGTERM 时优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	// 只支持2种认证方式，默认无需认证，当设置了用户名时需要通过用户名密码认证
 	method := socks5.MethodNoAuth
-	if username != "" {
+	if username != "" || cfg.Auth.UserFile != "" {
 		method = socks5.MethodUserPasswd
 	}
+	// 并发连接数上限，开启管理接口时也创建，以便查看连接数统计
+	var connLimiter *socks5.ConnLimiter
+	if cfg.Limits.MaxConns > 0 || cfg.Limits.MaxConnsPerUser > 0 || cfg.Limits.MaxConnsPerIP > 0 || cfg.Admin.Addr != "" {
+		connLimiter = &socks5.ConnLimiter{
+			MaxConns:        cfg.Limits.MaxConns,
+			MaxConnsPerUser: cfg.Limits.MaxConnsPerUser,
+			MaxConnsPerIP:   cfg.Limits.MaxConnsPerIP,
+			QueueTimeout:    time.Duration(cfg.Limits.ConnQueueTimeout),
+		}
+	}
+	// 监控指标
+	var metrics *socks5.Metrics
+	if cfg.Metrics.Addr != "" {
+		metrics = socks5.NewMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go func() {
+			slog.Info("start metrics server ...", "metricsAddr", cfg.Metrics.Addr)
+			if err := http.ListenAndServe(cfg.Metrics.Addr, mux); err != nil {
+				slog.Error("metrics server failed", "err", err)
+			}
+		}()
+	}
 	if !isServer {
 		// 本地客户端代理socks5
-		address = "127.0.0.1"
+		if address == "" {
+			address = "127.0.0.1"
+		}
 		client := &socks5.Client{
 			Addr:       fmt.Sprintf("%s:%d", address, port),
 			RemoteAddr: fmt.Sprintf("%s:%d", remoteAddr, remotePort),
 			Username:   username,
 			Passwd:     passwd,
+
+			ConnLimiter: connLimiter,
+			Metrics:     metrics,
+		}
+		if cfg.Upstream.TLS.Enabled || cfg.Upstream.TLS.CA != "" || cfg.Upstream.TLS.ServerName != "" || cfg.Upstream.TLS.Insecure || cfg.Upstream.TLS.Cert != "" {
+			client.TLS = &socks5.TLSClientConfig{
+				CAFile:             cfg.Upstream.TLS.CA,
+				ServerName:         cfg.Upstream.TLS.ServerName,
+				InsecureSkipVerify: cfg.Upstream.TLS.Insecure,
+				CertFile:           cfg.Upstream.TLS.Cert,
+				KeyFile:            cfg.Upstream.TLS.Key,
+			}
 		}
 		slog.Info("start sockes5 clinet (local server) ...", "port", port, "username", username, "passwd", passwd)
-		client.Run()
+		if err := serveUntilSignal(ctx, client.ListenAndServe, client.Shutdown, time.Duration(cfg.Timeouts.Shutdown)); err != nil {
+			slog.Error("client stopped", "err", err)
+			return 1
+		}
 	} else {
 		server := &socks5.Socks5Server{
 			Address:    address,
-			Port:       int16(port),
+			Port:       port,
 			IsServer:   isServer,
 			RemoteAddr: remoteAddr,
-			RemotePort: int16(remotePort),
+			RemotePort: remotePort,
 
 			Config: socks5.Config{
-				Timeout:  30 * time.Second,
-				Method:   method,
-				Username: username,
-				Passwd:   passwd,
+				Timeout:       time.Duration(cfg.Timeouts.Handshake),
+				BindTimeout:   time.Duration(cfg.Timeouts.Bind),
+				Method:        method,
+				Username:      username,
+				Passwd:        passwd,
+				EnableSocks4:  cfg.Listen.Socks4,
+				EnableSocks4a: cfg.Listen.Socks4,
+				// 默认禁止访问内网地址
+				AllowPrivateDestinations: cfg.Rules.AllowPrivate,
+				ConnLimiter:              connLimiter,
+				Metrics:                  metrics,
+				AdminToken:               cfg.Admin.Token,
 				CheckAuthFunc: func(userName, password string) bool {
-					return userName == username && password == passwd
+					userOk := subtle.ConstantTimeCompare([]byte(userName), []byte(username))
+					passwdOk := subtle.ConstantTimeCompare([]byte(password), []byte(passwd))
+					return userOk&passwdOk == 1
 				},
 			},
 		}
+		// 用户文件，文件修改后自动重新加载
+		if cfg.Auth.UserFile != "" {
+			db, err := socks5.NewUserDB(cfg.Auth.UserFile)
+			if err != nil {
+				slog.Error("load user file failed", "err", err)
+				return 1
+			}
+			go db.Watch(ctx, 5*time.Second)
+			server.Config.CheckAuthFunc = db.Check
+		}
+		// 客户端来源地址过滤
+		for _, item := range []struct {
+			value    []string
+			networks *[]netip.Prefix
+		}{{cfg.Rules.AllowClients, &server.Config.AllowedClients}, {cfg.Rules.DenyClients, &server.Config.DeniedClients}} {
+			networks, err := parseCIDRs(item.value)
+			if err != nil {
+				slog.Error("invalid client networks", "err", err)
+				return 1
+			}
+			*item.networks = networks
+		}
+		// 目标地址访问控制
+		if cfg.Rules.ACLFile != "" {
+			acl, err := socks5.LoadACL(cfg.Rules.ACLFile)
+			if err != nil {
+				slog.Error("load acl file failed", "err", err)
+				return 1
+			}
+			server.Config.ACL = acl
+		} else if cfg.Rules.ACL != nil {
+			// 已在 loadConfig 中校验
+			server.Config.ACL = cfg.Rules.ACL
+		}
+		// 限速，开启管理接口时也创建，以便运行时调整
+		if cfg.Limits.GlobalRate > 0 || cfg.Limits.UserRate > 0 || cfg.Limits.ConnRate > 0 || cfg.Admin.Addr != "" {
+			server.Config.RateLimiter = socks5.NewRateLimiter(
+				socks5.Bandwidth{Upload: cfg.Limits.GlobalRate, Download: cfg.Limits.GlobalRate},
+				socks5.Bandwidth{Upload: cfg.Limits.UserRate, Download: cfg.Limits.UserRate},
+				socks5.Bandwidth{Upload: cfg.Limits.ConnRate, Download: cfg.Limits.ConnRate},
+			)
+		}
+		// 按用户统计流量，每 10 秒写入一次文件
+		if cfg.Accounting.File != "" {
+			accounting, err := socks5.OpenAccounting(cfg.Accounting.File)
+			if err != nil {
+				slog.Error("open accounting file failed", "err", err)
+				return 1
+			}
+			accounting.DefaultQuota = socks5.Quota{Daily: cfg.Accounting.DailyQuota, Monthly: cfg.Accounting.MonthlyQuota}
+			accounting.CutLiveSessions = cfg.Accounting.CutSessions
+			go accounting.Run(ctx, 10*time.Second)
+			defer accounting.Close()
+			server.Config.Accounting = accounting
+		}
+		// 访问日志，每个隧道结束时写一条，按大小和时间轮转
+		if cfg.Log.AccessLog != "" {
+			var writer io.Writer = os.Stdout
+			if cfg.Log.AccessLog != "-" {
+				file, err := socks5.OpenRotatingFile(cfg.Log.AccessLog, cfg.Log.AccessLogMaxSize, time.Duration(cfg.Log.AccessLogMaxAge), cfg.Log.AccessLogMaxBackups)
+				if err != nil {
+					slog.Error("open access log failed", "err", err)
+					return 1
+				}
+				defer file.Close()
+				writer = file
+			}
+			accessLog, err := socks5.NewAccessLog(writer, cfg.Log.AccessLogFormat)
+			if err != nil {
+				slog.Error("invalid access log", "err", err)
+				return 1
+			}
+			server.Config.AccessLog = accessLog
+		}
+		// 暴力破解防护：连续失败后逐次加倍延迟，达到次数后锁定
+		if cfg.Auth.MaxFailures > 0 {
+			server.Config.AuthThrottle = &socks5.AuthThrottle{
+				MaxFailures:     cfg.Auth.MaxFailures,
+				LockoutDuration: time.Duration(cfg.Auth.Lockout),
+			}
+		}
+		if cfg.TLS.Cert != "" {
+			server.TLS = &socks5.TLSServerConfig{
+				CertFile:           cfg.TLS.Cert,
+				KeyFile:            cfg.TLS.Key,
+				ClientCAFile:       cfg.TLS.ClientCA,
+				ClientCertOptional: cfg.TLS.ClientCertOptional,
+			}
+		}
+		// 指定网络的客户端无需认证，其余使用用户名密码认证
+		if len(cfg.Auth.NoAuthNetworks) > 0 && method == socks5.MethodUserPasswd {
+			networks, err := parseCIDRs(cfg.Auth.NoAuthNetworks)
+			if err != nil {
+				slog.Error("invalid noAuthNetworks", "err", err)
+				return 1
+			}
+			server.Config.Authenticators = []socks5.Authenticator{
+				&socks5.NoAuthAuthenticator{SourceNetworks: networks},
+				&socks5.UserPasswdAuthenticator{CheckAuthFunc: server.Config.CheckAuthFunc, Throttle: server.Config.AuthThrottle},
+			}
+		}
 		// slog.Debug("start sockes5 server ...", "port", "username", "passwd", "isServer", port, username, passwd, isServer)
 		// 正确写法，参数成对依次出现
+		if cfg.Admin.Addr != "" {
+			server.Config.Sessions = socks5.NewSessionRegistry()
+			go func() {
+				slog.Info("start admin server ...", "adminAddr", cfg.Admin.Addr)
+				if err := http.ListenAndServe(cfg.Admin.Addr, server.AdminHandler()); err != nil {
+					slog.Error("admin server failed", "err", err)
+				}
+			}()
+		}
 		slog.Info("start sockes5 server ...", "port", port, "username", username, "passwd", passwd, "isServer", isServer)
-		server.Run()
+		if err := serveUntilSignal(ctx, server.ListenAndServe, server.Shutdown, time.Duration(cfg.Timeouts.Shutdown)); err != nil {
+			slog.Error("server stopped", "err", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// serveUntilSignal 运行服务直到 ctx 取消（收到信号），然后在 timeout 内等待正在处理的连接结束，超时后强制关闭
+func serveUntilSignal(ctx context.Context, listenAndServe, shutdown func(context.Context) error, timeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() { served <- listenAndServe(context.Background()) }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
 	}
+	slog.Info("shutting down ...", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := shutdown(shutdownCtx)
+	if served := <-served; !errors.Is(served, socks5.ErrServerClosed) {
+		err = errors.Join(err, served)
+	}
+	return err
+}
 
+// parseCIDRs 解析 CIDR 列表
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range values {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
 }
 
 // 设置日志属性
@@ -0,0 +1,326 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessRecord 访问日志中的一条记录，每个隧道（一次请求）结束时写一条
+type AccessRecord struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session"`
+	ClientIP  string    `json:"client"`
+	User      string    `json:"user"`
+	// Command connect、bind、udp 或 http（HTTP 代理转发的普通请求）
+	Command string `json:"command"`
+	// Address 客户端请求的地址
+	Address string `json:"address"`
+	// ResolvedIP 实际连接的 IP，未连接时为空
+	ResolvedIP string    `json:"resolved"`
+	Reply      ReplyType `json:"reply"`
+	// Upload 客户端到目标的字节数，Download 目标到客户端的字节数
+	Upload   int64         `json:"upload"`
+	Download int64         `json:"download"`
+	Duration time.Duration `json:"duration"`
+	// Reason 结束的原因，如 client closed、target closed、killed，失败时为错误信息
+	Reason string `json:"reason"`
+}
+
+// MarshalJSON 时长以秒为单位
+func (r AccessRecord) MarshalJSON() ([]byte, error) {
+	type record AccessRecord
+	return json.Marshal(struct {
+		record
+		Duration float64 `json:"duration"`
+	}{record(r), r.Duration.Seconds()})
+}
+
+// 访问日志的格式
+const (
+	AccessLogJSON = "json"
+	AccessLogText = "text"
+)
+
+// AccessLog 访问日志，json 为每行一条 JSON，text 为类似 Common Log Format 的文本：
+//
+//	client - user [time] "COMMAND address" reply upload download duration session resolved "reason"
+type AccessLog struct {
+	format string
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewAccessLog format 为 json 或 text
+func NewAccessLog(writer io.Writer, format string) (*AccessLog, error) {
+	if format != AccessLogJSON && format != AccessLogText {
+		return nil, fmt.Errorf("access log format %q not supported", format)
+	}
+	return &AccessLog{format: format, writer: writer}, nil
+}
+
+// Log 写一条记录，l 为 nil 时不记录
+func (l *AccessLog) Log(record AccessRecord) {
+	if l == nil {
+		return
+	}
+	var line []byte
+	if l.format == AccessLogJSON {
+		var err error
+		if line, err = json.Marshal(record); err != nil {
+			slog.Error("marshal access record failed", "err", err)
+			return
+		}
+		line = append(line, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s - %s [%s] \"%s %s\" 0x%02x %d %d %.3f %d %s %q\n",
+			orDash(record.ClientIP), orDash(record.User), record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strings.ToUpper(record.Command), record.Address, record.Reply, record.Upload, record.Download,
+			record.Duration.Seconds(), record.SessionID, orDash(record.ResolvedIP), record.Reason))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.writer.Write(line); err != nil {
+		slog.Error("write access log failed", "err", err)
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// commandName 访问日志中的命令名称
+func commandName(command CommandType) string {
+	for name, value := range aclCommands {
+		if value == command {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%02x", command)
+}
+
+// logTunnel 隧道结束时写一条访问日志，err 为处理请求返回的错误
+func (s5 *Socks5Server) logTunnel(sess *session, err error) {
+	if s5.Config.AccessLog == nil || sess == nil {
+		return
+	}
+	s5.Config.AccessLog.Log(sess.accessRecord(err))
+}
+
+func (s *session) accessRecord(err error) AccessRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	record := AccessRecord{
+		Time:       now,
+		SessionID:  s.id,
+		User:       s.user,
+		Command:    s.command,
+		Address:    s.target,
+		ResolvedIP: s.resolvedIP,
+		Reply:      ReplySuccess,
+		Upload:     s.upload.Load() - s.tunnelUpload,
+		Download:   s.download.Load() - s.tunnelDownload,
+		Duration:   now.Sub(s.tunnelStart),
+		Reason:     s.reason,
+	}
+	if ip, ok := addrIP(s.clientAddr); ok {
+		record.ClientIP = ip.String()
+	} else if s.clientAddr != nil {
+		record.ClientIP = s.clientAddr.String()
+	}
+	// 未开始转发时按错误推断回复码
+	if !s.established {
+		record.Reply = dialErrorToReply(err)
+		if err == nil {
+			record.Reply = ReplyCommonFail
+		}
+	}
+	if record.Reason == "" {
+		record.Reason = "closed"
+		if err != nil {
+			record.Reason = err.Error()
+		}
+	}
+	return record
+}
+
+// backupTimeFormat 轮转文件名中的时间
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile 按大小和时间轮转的日志文件，轮转后的文件名为 path.20060102-150405.000，同一毫秒内多次轮转时再加上 .1、.2 等序号
+type RotatingFile struct {
+	// MaxSize 文件超过该大小（字节）时轮转，0 表示不按大小轮转
+	MaxSize int64
+	// MaxAge 文件打开超过该时间后轮转，0 表示不按时间轮转
+	MaxAge time.Duration
+	// MaxBackups 保留的轮转文件数，0 表示全部保留
+	MaxBackups int
+
+	path string
+	// now 当前时间，测试时替换为假时钟
+	now func() time.Time
+	// rename 轮转时改名，测试时替换以模拟失败
+	rename func(oldpath, newpath string) error
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+// OpenRotatingFile 以追加方式打开日志文件
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups, path: path, now: time.Now, rename: os.Rename}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	// 上次轮转时没能打开文件，每次写入时重试
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooBig := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	tooOld := f.MaxAge > 0 && f.now().Sub(f.opened) >= f.MaxAge
+	if tooBig || tooOld {
+		// 轮转失败时继续写入重新打开的文件，只有文件无法打开时才丢弃
+		if err := f.rotate(); err != nil {
+			slog.Error("rotate access log failed", "file", f.path, "err", err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate 改名当前文件并打开新文件，超出 MaxBackups 时删除最旧的轮转文件
+// 改名失败时重新打开原文件继续追加，再写入 MaxSize 字节或经过 MaxAge 后才重试，避免每次写入都重试
+func (f *RotatingFile) rotate() error {
+	closeErr := f.file.Close()
+	f.file = nil
+	renameErr := f.rename(f.path, f.backupName())
+	if err := f.open(); err != nil {
+		return errors.Join(closeErr, renameErr, err)
+	}
+	if renameErr != nil {
+		f.size = 0
+	}
+	if err := errors.Join(closeErr, renameErr); err != nil {
+		return err
+	}
+	if f.MaxBackups > 0 {
+		backups := f.backups()
+		for len(backups) > f.MaxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				slog.Error("remove old access log failed", "file", backups[0], "err", err)
+			}
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// backupName 轮转文件名，已存在时加序号，避免同一毫秒内多次轮转时覆盖
+func (f *RotatingFile) backupName() string {
+	name := f.path + "." + f.now().Format(backupTimeFormat)
+	backup := name
+	for seq := 1; ; seq++ {
+		if _, err := os.Lstat(backup); err != nil {
+			return backup
+		}
+		backup = name + "." + strconv.Itoa(seq)
+	}
+}
+
+// backups 轮转后的文件，按时间和序号从旧到新排序
+func (f *RotatingFile) backups() []string {
+	type backup struct {
+		name  string
+		stamp string
+		seq   int
+	}
+	matches, _ := filepath.Glob(f.path + ".*")
+	var backups []backup
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, f.path+".")
+		if len(suffix) < len(backupTimeFormat) {
+			continue
+		}
+		stamp, rest := suffix[:len(backupTimeFormat)], suffix[len(backupTimeFormat):]
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		seq := 0
+		if rest != "" {
+			digits, ok := strings.CutPrefix(rest, ".")
+			n, err := strconv.Atoi(digits)
+			if !ok || err != nil || n <= 0 {
+				continue
+			}
+			seq = n
+		}
+		backups = append(backups, backup{name: match, stamp: stamp, seq: seq})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	names := make([]string, len(backups))
+	for i, backup := range backups {
+		names[i] = backup.name
+	}
+	return names
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,238 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// linesForTest 每次 Write 为一行，从 channel 中取出
+type linesForTest chan string
+
+func (l linesForTest) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func (l linesForTest) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-l:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want get access log but got nothing")
+		return ""
+	}
+}
+
+func TestAccessLog_Format(t *testing.T) {
+	record := AccessRecord{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		SessionID:  7,
+		ClientIP:   "198.51.100.7",
+		User:       "alice",
+		Command:    "connect",
+		Address:    "example.com:443",
+		ResolvedIP: "93.184.216.34",
+		Reply:      ReplySuccess,
+		Upload:     100,
+		Download:   2000,
+		Duration:   1500 * time.Millisecond,
+		Reason:     "client closed",
+	}
+	lines := make(linesForTest, 1)
+	textLog, _ := NewAccessLog(lines, AccessLogText)
+	textLog.Log(record)
+	want := `198.51.100.7 - alice [02/Jan/2024:03:04:05 +0000] "CONNECT example.com:443" 0x00 100 2000 1.500 7 93.184.216.34 "client closed"` + "\n"
+	if got := lines.next(t); got != want {
+		t.Fatalf("want get %q but got %q", want, got)
+	}
+
+	jsonLog, _ := NewAccessLog(lines, AccessLogJSON)
+	jsonLog.Log(record)
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines.next(t)), &got); err != nil {
+		t.Fatalf("json.Unmarshal error %s", err)
+	}
+	if got["duration"] != 1.5 || got["session"] != 7.0 || got["resolved"] != "93.184.216.34" || got["reply"] != 0.0 || got["reason"] != "client closed" {
+		t.Fatalf("want get record fields but got %v", got)
+	}
+
+	if _, err := NewAccessLog(lines, "xml"); err == nil {
+		t.Fatalf("want get err but got nil")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	f, err := OpenRotatingFile(path, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile error %s", err)
+	}
+	defer f.Close()
+	f.now = clock.Now
+	f.opened = clock.Now()
+
+	// 按大小轮转，只保留 2 个轮转文件
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		clock.Advance(time.Second)
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+	}
+	backups := f.backups()
+	if len(backups) != 2 || filepath.Base(backups[0]) != "access.log.20240102-000003.000" {
+		t.Fatalf("want get 2 backups but got %v", backups)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != "bbbbbb\n" {
+		t.Fatalf("want get bbbbbb but got %q", content)
+	}
+
+	// 按时间轮转
+	clock.Advance(time.Hour)
+	f.Write([]byte("e\n"))
+	if content, _ := os.ReadFile(path); string(content) != "e\n" {
+		t.Fatalf("want get e but got %q", content)
+	}
+	if backups := f.backups(); len(backups) != 2 {
+		t.Fatalf("want get 2 backups but got %v", backups)
+	}
+}
+
+func TestRotatingFile_SameMillisecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	f, err := OpenRotatingFile(path, 4, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile error %s", err)
+	}
+	defer f.Close()
+	f.now = clock.Now
+	// 时钟不前进，每次写入都在同一毫秒内轮转，轮转文件不能互相覆盖
+	for i := 0; i < 12; i++ {
+		f.Write([]byte(fmt.Sprintf("%03d\n", i)))
+	}
+	backups := f.backups()
+	if len(backups) != 11 || filepath.Base(backups[10]) != "access.log.20240102-000000.000.10" {
+		t.Fatalf("want get 11 backups but got %v", backups)
+	}
+	for i, backup := range backups {
+		if content, _ := os.ReadFile(backup); string(content) != fmt.Sprintf("%03d\n", i) {
+			t.Fatalf("want get %03d in %s but got %q", i, backup, content)
+		}
+	}
+}
+
+func TestRotatingFile_ReopenAfterFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	os.Mkdir(dir, 0700)
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 4, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile error %s", err)
+	}
+	defer f.Close()
+	f.Write([]byte("aaa\n"))
+	// 目录被删除，轮转时改名和重新打开都失败
+	os.RemoveAll(dir)
+	if _, err := f.Write([]byte("bbb\n")); err == nil {
+		t.Fatalf("want get err but got nil")
+	}
+	// 目录恢复后重新打开原路径继续写入
+	os.Mkdir(dir, 0700)
+	if _, err := f.Write([]byte("ccc\n")); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "ccc\n" {
+		t.Fatalf("want get ccc but got %q", content)
+	}
+	f.Close()
+	if _, err := f.Write([]byte("ddd\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("want get os.ErrClosed but got %v", err)
+	}
+}
+
+func TestRotatingFile_RenameFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 8, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile error %s", err)
+	}
+	defer f.Close()
+	renames := 0
+	f.rename = func(oldpath, newpath string) error {
+		renames++
+		return os.ErrPermission
+	}
+	// 改名失败后继续写入原文件，再写入 MaxSize 字节后才重试
+	for _, line := range []string{"aaa\n", "bbb\n", "ccc\n", "ddd\n", "eee\n", "fff\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+	}
+	if renames != 2 {
+		t.Fatalf("want get 2 rename attempts but got %d", renames)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "aaa\nbbb\nccc\nddd\neee\nfff\n" {
+		t.Fatalf("want get all lines but got %q", content)
+	}
+}
+
+func TestSocks5Server_AccessLog(t *testing.T) {
+	lines := make(linesForTest, 10)
+	accessLog, _ := NewAccessLog(lines, AccessLogJSON)
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, passwd string) bool {
+			return passwd == "123456"
+		},
+		Sessions:  NewSessionRegistry(),
+		AccessLog: accessLog,
+	}}
+	proxyAddr := serveForTest(t, s)
+	dialer := &Dialer{ProxyAddr: proxyAddr, Username: "alice", Passwd: "123456", Timeout: 5 * time.Second}
+
+	conn, err := dialer.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+	line := lines.next(t)
+	var record map[string]any
+	json.Unmarshal([]byte(line), &record)
+	want := map[string]any{"client": "127.0.0.1", "user": "alice", "command": "connect", "address": target.String(),
+		"resolved": "127.0.0.1", "reply": 0.0, "upload": 4.0, "download": 4.0, "reason": "client closed"}
+	for key, value := range want {
+		if record[key] != value {
+			t.Fatalf("want get %s %v but got %s", key, value, line)
+		}
+	}
+	if record["session"] == 0.0 {
+		t.Fatalf("want get session id but got %s", line)
+	}
+
+	// 连接被拒绝
+	listen, _ := net.Listen("tcp", "127.0.0.1:0")
+	listen.Close()
+	dialer.Dial("tcp", listen.Addr().String())
+	line = lines.next(t)
+	json.Unmarshal([]byte(line), &record)
+	if record["reply"] != float64(ReplyConnectionDenied) || !strings.Contains(record["reason"].(string), "refused") {
+		t.Fatalf("want get connection refused record but got %s", line)
+	}
+}
@@ -0,0 +1,413 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded 用户流量超出配额
+var ErrQuotaExceeded = fmt.Errorf("%w: quota exceeded", ErrAccessDenied)
+
+// Usage 流量，单位字节
+type Usage struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+func (u Usage) total() int64 {
+	return u.Upload + u.Download
+}
+
+func (u *Usage) add(other Usage) {
+	u.Upload += other.Upload
+	u.Download += other.Download
+}
+
+// Quota 流量配额，上传下载合计，单位字节，0 表示不限制
+type Quota struct {
+	Daily   int64 `json:"daily"`
+	Monthly int64 `json:"monthly"`
+}
+
+// UserUsage 用户的流量统计，用于管理接口展示
+type UserUsage struct {
+	User  string `json:"user"`
+	Total Usage  `json:"total"`
+	Today Usage  `json:"today"`
+	Month Usage  `json:"month"`
+	Quota Quota  `json:"quota"`
+}
+
+// usageRecord 文件中的一行，某用户某天的流量增量
+type usageRecord struct {
+	Day      string `json:"day"`
+	User     string `json:"user"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+type usageKey struct {
+	user string
+	day  string
+}
+
+// periodUsage 用户当天和当月的流量合计，转发时累加，日期变化后重新计算
+type periodUsage struct {
+	day   string
+	today int64
+	month int64
+}
+
+// Accounting 按用户统计转发的流量并执行每日、每月配额
+// 流量以每行一条 JSON 的形式追加写入文件，启动时重放恢复，并按用户和日期合并压缩
+type Accounting struct {
+	// DefaultQuota 未单独设置配额的用户的配额
+	DefaultQuota Quota
+	// CutLiveSessions 超出配额时同时断开该用户正在转发的连接，否则只拒绝新的请求
+	CutLiveSessions bool
+
+	// now 当前时间，测试时替换为假时钟
+	now func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	quotas map[string]Quota
+	// days 每个用户每天的流量
+	days map[string]map[string]Usage
+	// periods 每个用户当天和当月的合计，检查配额时不必遍历 days
+	periods map[string]*periodUsage
+	// pending 尚未写入文件的增量
+	pending map[usageKey]Usage
+	// live 正在转发的连接，超出配额时断开
+	live map[string]map[*context.CancelFunc]struct{}
+}
+
+// OpenAccounting 打开流量统计文件，不存在时创建
+func OpenAccounting(file string) (*Accounting, error) {
+	a := &Accounting{
+		quotas:  map[string]Quota{},
+		days:    map[string]map[string]Usage{},
+		periods: map[string]*periodUsage{},
+		pending: map[usageKey]Usage{},
+		live:    map[string]map[*context.CancelFunc]struct{}{},
+	}
+	if err := a.load(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := a.compact(file); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+// load 重放文件中的记录，忽略最后一行写了一半的记录
+func (a *Accounting) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("skip invalid accounting record", "file", file, "line", lineNo, "err", err)
+			continue
+		}
+		a.addDay(record.User, record.Day, Usage{Upload: record.Upload, Download: record.Download})
+	}
+	return scanner.Err()
+}
+
+// compact 每个用户每天只保留一条记录，先写临时文件再改名
+func (a *Accounting) compact(file string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	for _, user := range sortedKeys(a.days) {
+		for _, day := range sortedKeys(a.days[user]) {
+			if err := writeUsageRecord(writer, user, day, a.days[user][day]); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func writeUsageRecord(w io.Writer, user, day string, usage Usage) error {
+	line, err := json.Marshal(usageRecord{Day: day, User: user, Upload: usage.Upload, Download: usage.Download})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// SetQuota 单独设置用户的配额
+func (a *Accounting) SetQuota(user string, quota Quota) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.quotas[user] = quota
+}
+
+// Run 每隔 interval 将增量写入文件，直到 ctx 结束
+func (a *Accounting) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				slog.Error("flush accounting failed", "err", err)
+			}
+		}
+	}
+}
+
+// Flush 将尚未写入的增量追加到文件
+func (a *Accounting) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pending) == 0 || a.file == nil {
+		return nil
+	}
+	writer := bufio.NewWriter(a.file)
+	for key, usage := range a.pending {
+		if err := writeUsageRecord(writer, key.user, key.day, usage); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	a.pending = map[usageKey]Usage{}
+	return nil
+}
+
+// Close 写入增量并关闭文件
+func (a *Accounting) Close() error {
+	err := a.Flush()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		err = errors.Join(err, a.file.Close())
+		a.file = nil
+	}
+	return err
+}
+
+// Usages 所有用户的流量统计，按用户名排序
+func (a *Accounting) Usages() []UserUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	today, month := a.today()
+	usages := []UserUsage{}
+	for _, user := range sortedKeys(a.days) {
+		usage := UserUsage{User: user, Quota: a.quota(user)}
+		for day, dayUsage := range a.days[user] {
+			usage.Total.add(dayUsage)
+			if strings.HasPrefix(day, month) {
+				usage.Month.add(dayUsage)
+			}
+			if day == today {
+				usage.Today.add(dayUsage)
+			}
+		}
+		usages = append(usages, usage)
+	}
+	return usages
+}
+
+// Exceeded 用户当天或当月的流量是否已超出配额
+func (a *Accounting) Exceeded(user string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.exceeded(user)
+}
+
+// exceeded 调用方需持有锁
+func (a *Accounting) exceeded(user string) bool {
+	quota := a.quota(user)
+	if quota.Daily <= 0 && quota.Monthly <= 0 {
+		return false
+	}
+	today, month := a.today()
+	period := a.period(user, today, month)
+	return (quota.Daily > 0 && period.today >= quota.Daily) || (quota.Monthly > 0 && period.month >= quota.Monthly)
+}
+
+// period 用户当天和当月的合计，每个用户每天第一次使用时从 days 计算，调用方需持有锁
+func (a *Accounting) period(user, today, month string) *periodUsage {
+	period := a.periods[user]
+	if period != nil && period.day == today {
+		return period
+	}
+	period = &periodUsage{day: today}
+	for day, usage := range a.days[user] {
+		if strings.HasPrefix(day, month) {
+			period.month += usage.total()
+		}
+		if day == today {
+			period.today += usage.total()
+		}
+	}
+	a.periods[user] = period
+	return period
+}
+
+func (a *Accounting) quota(user string) Quota {
+	if quota, ok := a.quotas[user]; ok {
+		return quota
+	}
+	return a.DefaultQuota
+}
+
+// today 当天和当月，如 2024-01-02 和 2024-01
+func (a *Accounting) today() (string, string) {
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	return now.Format("2006-01-02"), now.Format("2006-01")
+}
+
+func (a *Accounting) addDay(user, day string, usage Usage) {
+	days := a.days[user]
+	if days == nil {
+		days = map[string]Usage{}
+		a.days[user] = days
+	}
+	dayUsage := days[day]
+	dayUsage.add(usage)
+	days[day] = dayUsage
+}
+
+// count 记录转发的流量，开启 CutLiveSessions 时超出配额立即断开该用户的连接
+func (a *Accounting) count(user string, usage Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	today, month := a.today()
+	period := a.period(user, today, month)
+	a.addDay(user, today, usage)
+	period.today += usage.total()
+	period.month += usage.total()
+	key := usageKey{user: user, day: today}
+	pending := a.pending[key]
+	pending.add(usage)
+	a.pending[key] = pending
+	if a.CutLiveSessions && len(a.live[user]) > 0 && a.exceeded(user) {
+		slog.Warn("quota exceeded, cut live sessions", "user", user, "sessions", len(a.live[user]))
+		for cancel := range a.live[user] {
+			(*cancel)()
+		}
+		delete(a.live, user)
+	}
+}
+
+// track 登记正在转发的连接，返回的函数在连接结束时调用
+func (a *Accounting) track(user string, cancel context.CancelFunc) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.live[user] == nil {
+		a.live[user] = map[*context.CancelFunc]struct{}{}
+	}
+	a.live[user][&cancel] = struct{}{}
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.live[user], &cancel)
+		if len(a.live[user]) == 0 {
+			delete(a.live, user)
+		}
+	}
+}
+
+// checkQuota 新的请求前检查用户配额，未认证的客户端不统计
+func (s5 *Socks5Server) checkQuota(sess *session) error {
+	accounting := s5.Config.Accounting
+	if accounting == nil || sess == nil {
+		return nil
+	}
+	// HTTP 代理的 Transport 在其他协程中连接目标时也会检查，加锁读取用户
+	user := sess.userName()
+	if user == "" {
+		return nil
+	}
+	if accounting.Exceeded(user) {
+		slog.Warn("quota exceeded, request rejected", "user", user, "remoteAddr", sess.clientAddr)
+		return fmt.Errorf("%w: user %q", ErrQuotaExceeded, user)
+	}
+	return nil
+}
+
+// countingReader 统计读取的字节数，ctx 结束（如超出配额被断开）后不再读取
+type countingReader struct {
+	ctx    context.Context
+	reader io.Reader
+	count  func(n int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.count(int64(n))
+	}
+	return n, err
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	writer io.Writer
+	count  func(n int64)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.count(int64(n))
+	}
+	return n, err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
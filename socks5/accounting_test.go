@@ -0,0 +1,207 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func accountingForTest(t *testing.T, file string, clock *fakeClock) *Accounting {
+	t.Helper()
+	accounting, err := OpenAccounting(file)
+	if err != nil {
+		t.Fatalf("OpenAccounting error %s", err)
+	}
+	accounting.now = clock.Now
+	t.Cleanup(func() { accounting.Close() })
+	return accounting
+}
+
+func TestAccounting_Persist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)}
+	accounting := accountingForTest(t, file, clock)
+	accounting.count("alice", Usage{Upload: 100})
+	accounting.count("alice", Usage{Download: 200})
+	accounting.Flush()
+	accounting.count("alice", Usage{Upload: 1})
+	accounting.Flush()
+	clock.Advance(24 * time.Hour)
+	accounting.count("alice", Usage{Download: 50})
+	accounting.count("bob", Usage{Upload: 7})
+	if err := accounting.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	// 模拟写了一半的最后一行
+	f, _ := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"day":"2024-02-01","user":"alice","upl`)
+	f.Close()
+
+	reopened := accountingForTest(t, file, clock)
+	want := []UserUsage{
+		{User: "alice", Total: Usage{Upload: 101, Download: 250}, Today: Usage{Download: 50}, Month: Usage{Download: 50}},
+		{User: "bob", Total: Usage{Upload: 7}, Today: Usage{Upload: 7}, Month: Usage{Upload: 7}},
+	}
+	got := reopened.Usages()
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Fatalf("want get %s but got %s", wantJSON, gotJSON)
+	}
+	// 重新打开时每个用户每天合并为一条记录
+	content, _ := os.ReadFile(file)
+	if lines := bytes.Count(content, []byte("\n")); lines != 3 {
+		t.Fatalf("want get 3 lines after compact but got %d:\n%s", lines, content)
+	}
+}
+
+func TestAccounting_Quota(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)}
+	accounting := accountingForTest(t, filepath.Join(t.TempDir(), "usage.log"), clock)
+	accounting.DefaultQuota = Quota{Daily: 100, Monthly: 250}
+	accounting.SetQuota("vip", Quota{})
+
+	accounting.count("alice", Usage{Upload: 60, Download: 40})
+	if !accounting.Exceeded("alice") {
+		t.Fatalf("want get daily quota exceeded but got not")
+	}
+	accounting.count("vip", Usage{Download: 1 << 30})
+	if accounting.Exceeded("vip") {
+		t.Fatalf("want get vip unlimited but got exceeded")
+	}
+	// 第二天日配额恢复
+	clock.Advance(24 * time.Hour)
+	if accounting.Exceeded("alice") {
+		t.Fatalf("want get daily quota reset but got exceeded")
+	}
+	accounting.count("alice", Usage{Upload: 99})
+	accounting.count("alice", Usage{Upload: 51})
+	// 同月合计 250，超出月配额
+	clock.Advance(time.Hour)
+	accounting.count("alice", Usage{})
+	if !accounting.Exceeded("alice") {
+		t.Fatalf("want get monthly quota exceeded but got not")
+	}
+	// 下个月恢复
+	clock.Advance(24 * time.Hour)
+	if accounting.Exceeded("alice") {
+		t.Fatalf("want get monthly quota reset but got exceeded")
+	}
+}
+
+func TestAccounting_QuotaAfterReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)}
+	accounting := accountingForTest(t, file, clock)
+	accounting.count("alice", Usage{Upload: 60})
+	clock.Advance(24 * time.Hour)
+	accounting.count("alice", Usage{Download: 30})
+	accounting.Close()
+
+	// 重新打开后当天和当月的合计从文件恢复，之后的流量继续累加
+	reopened := accountingForTest(t, file, clock)
+	reopened.DefaultQuota = Quota{Daily: 50, Monthly: 100}
+	if reopened.Exceeded("alice") {
+		t.Fatalf("want get not exceeded but got exceeded")
+	}
+	reopened.count("alice", Usage{Upload: 9})
+	if reopened.Exceeded("alice") {
+		t.Fatalf("want get not exceeded at 99 but got exceeded")
+	}
+	reopened.count("alice", Usage{Upload: 1})
+	if !reopened.Exceeded("alice") {
+		t.Fatalf("want get monthly quota exceeded but got not")
+	}
+}
+
+func TestAccounting_Forward(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	accounting := accountingForTest(t, filepath.Join(t.TempDir(), "usage.log"), clock)
+	s := &Socks5Server{Config: Config{Accounting: accounting, AdminToken: "secret"}}
+	client, target := forwardForTest(t, s, "alice")
+	transferForTest(t, client, target, 1000)
+	transferForTest(t, target, client, 3000)
+
+	_, body := adminForTest(t, s.AdminHandler(), http.MethodGet, "/usage", "secret")
+	var usages []UserUsage
+	json.Unmarshal([]byte(body), &usages)
+	if len(usages) != 1 || usages[0].Total != (Usage{Upload: 1000, Download: 3000}) {
+		t.Fatalf("want get alice 1000/3000 but got %s", body)
+	}
+}
+
+func TestAccounting_CutLiveSessions(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	accounting := accountingForTest(t, filepath.Join(t.TempDir(), "usage.log"), clock)
+	accounting.DefaultQuota = Quota{Daily: 64 << 10}
+	accounting.CutLiveSessions = true
+	s := &Socks5Server{Config: Config{Accounting: accounting}}
+	client, target := forwardForTest(t, s, "alice")
+	go func() {
+		for {
+			if _, err := target.Write(make([]byte, 16<<10)); err != nil {
+				return
+			}
+		}
+	}()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := io.Copy(io.Discard, client)
+	if err != nil || n > 128<<10 {
+		t.Fatalf("want get session cut near quota but got %d bytes %v", n, err)
+	}
+	if err := s.checkQuota(&session{user: "alice"}); !errors.Is(err, ErrQuotaExceeded) || dialErrorToReply(err) != ReplyRegularDenied {
+		t.Fatalf("want get ErrQuotaExceeded but got %v", err)
+	}
+}
+
+func TestAccounting_HTTPForward(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	accounting := accountingForTest(t, filepath.Join(t.TempDir(), "usage.log"), clock)
+	accounting.DefaultQuota = Quota{Daily: 4000}
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return userName == "alice" && password == "123456"
+		},
+		Accounting: accounting,
+	}}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(make([]byte, 3000))
+	}))
+	defer httpServer.Close()
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("alice", "123456"), Host: serveForTest(t, s)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Post(httpServer.URL, "application/octet-stream", bytes.NewReader(make([]byte, 1000)))
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// 超出配额后的请求被拒绝
+	resp, err = client.Get(httpServer.URL)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("want get 403 but got %d", resp.StatusCode)
+	}
+
+	// 上传为请求体，下载为包括响应头的整个响应
+	usages := accounting.Usages()
+	if len(usages) != 1 || usages[0].Total.Upload != 1000 || usages[0].Total.Download <= 3000 {
+		t.Fatalf("want get alice 1000/3000+ but got %+v", usages)
+	}
+}
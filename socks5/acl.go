@@ -0,0 +1,275 @@
+package socks5
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ACL 目标地址访问控制，按顺序第一条匹配的规则生效，没有规则匹配时使用 DefaultAction
+// 通过 LoadACL 从 JSON 文件加载，或构造后调用 Compile
+//
+//	{"defaultAction": "deny", "rules": [
+//	  {"name": "no-smtp", "action": "deny", "ports": ["25"]},
+//	  {"name": "web", "action": "allow", "domains": ["*.example.com"], "ports": ["80", "443"], "commands": ["connect"]},
+//	  {"name": "ops", "action": "allow", "networks": ["10.0.0.0/8"], "users": ["alice"]}
+//	]}
+type ACL struct {
+	Rules []ACLRule `json:"rules"`
+	// DefaultAction 没有规则匹配时的动作，allow 或 deny，默认 allow
+	DefaultAction string `json:"defaultAction,omitempty"`
+}
+
+// ACLRule 访问控制规则，所有非空的条件都满足时匹配
+type ACLRule struct {
+	// Name 规则名称，用于日志，为空时为 rule[序号]
+	Name string `json:"name,omitempty"`
+	// Action allow 或 deny
+	Action string `json:"action"`
+	// Networks 目标 IP 所在网络，CIDR 或单个 IP；目标为域名时匹配解析后实际连接的 IP
+	Networks []string `json:"networks,omitempty"`
+	// Domains 目标域名，*.example.com 匹配所有子域名（不含 example.com 本身）；目标为 IP 时不匹配
+	Domains []string `json:"domains,omitempty"`
+	// Ports 目标端口，单个端口 443 或端口范围 8000-9000
+	Ports []string `json:"ports,omitempty"`
+	// Commands connect、bind 或 udp，HTTP 代理的请求视为 connect
+	Commands []string `json:"commands,omitempty"`
+	// Users 认证得到的身份（用户名或客户端证书的身份）
+	Users []string `json:"users,omitempty"`
+
+	allow    bool
+	networks []netip.Prefix
+	ports    [][2]uint16
+	commands []CommandType
+}
+
+// aclTarget 访问控制判断的请求
+type aclTarget struct {
+	user    string
+	command CommandType
+	// domain 请求的域名，请求的是 IP 时为空
+	domain string
+	// ip 实际连接的 IP，未解析时无效
+	ip   netip.Addr
+	port uint16
+}
+
+// aclCommands 规则中的命令名称
+var aclCommands = map[string]CommandType{
+	"connect": CommandConnect,
+	"bind":    CommandBind,
+	"udp":     CommandUdpAssociate,
+}
+
+// LoadACL 从 JSON 文件加载访问控制规则
+func LoadACL(file string) (*ACL, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	acl := &ACL{}
+	if err := json.Unmarshal(content, acl); err != nil {
+		return nil, fmt.Errorf("parse acl %s failed: %w", file, err)
+	}
+	if err := acl.Compile(); err != nil {
+		return nil, fmt.Errorf("parse acl %s failed: %w", file, err)
+	}
+	return acl, nil
+}
+
+// Compile 校验并解析规则，使用前必须调用，LoadACL 已调用
+func (a *ACL) Compile() error {
+	switch a.DefaultAction {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("defaultAction: invalid action %q", a.DefaultAction)
+	}
+	for i := range a.Rules {
+		rule := &a.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule[%d]", i)
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("%s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *ACLRule) compile() error {
+	switch r.Action {
+	case "allow":
+		r.allow = true
+	case "deny":
+		r.allow = false
+	default:
+		return fmt.Errorf("invalid action %q", r.Action)
+	}
+	r.networks = r.networks[:0]
+	for _, network := range r.Networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(network)
+			if ipErr != nil {
+				return fmt.Errorf("invalid network %q", network)
+			}
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		r.networks = append(r.networks, prefix.Masked())
+	}
+	r.ports = r.ports[:0]
+	for _, port := range r.Ports {
+		first, last, isRange := strings.Cut(port, "-")
+		if !isRange {
+			last = first
+		}
+		from, err1 := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+		to, err2 := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+		if err1 != nil || err2 != nil || from > to {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		r.ports = append(r.ports, [2]uint16{uint16(from), uint16(to)})
+	}
+	r.commands = r.commands[:0]
+	for _, command := range r.Commands {
+		commandType, ok := aclCommands[strings.ToLower(command)]
+		if !ok {
+			return fmt.Errorf("invalid command %q", command)
+		}
+		r.commands = append(r.commands, commandType)
+	}
+	for _, domain := range r.Domains {
+		if strings.TrimPrefix(domain, "*.") == "" || strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// decide 返回是否允许以及匹配的规则名称
+func (a *ACL) decide(target aclTarget) (bool, string) {
+	for i := range a.Rules {
+		if a.Rules[i].match(target) {
+			return a.Rules[i].allow, a.Rules[i].Name
+		}
+	}
+	return a.DefaultAction != "deny", "default"
+}
+
+func (r *ACLRule) match(target aclTarget) bool {
+	if len(r.networks) > 0 {
+		if !target.ip.IsValid() || !prefixesContain(r.networks, target.ip) {
+			return false
+		}
+	}
+	if len(r.Domains) > 0 && !domainMatch(r.Domains, target.domain) {
+		return false
+	}
+	if len(r.ports) > 0 {
+		matched := false
+		for _, ports := range r.ports {
+			if target.port >= ports[0] && target.port <= ports[1] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.commands) > 0 && !containsCommand(r.commands, target.command) {
+		return false
+	}
+	if len(r.Users) > 0 && !containsString(r.Users, target.user) {
+		return false
+	}
+	return true
+}
+
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainMatch 不区分大小写，忽略末尾的点
+func domainMatch(patterns []string, domain string) bool {
+	if domain == "" {
+		return false
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(domain, suffix) {
+				return true
+			}
+		} else if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCommand(commands []CommandType, command CommandType) bool {
+	for _, c := range commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccess 按访问控制规则判断请求，拒绝时返回 ErrAccessDenied；没有配置 ACL 时全部允许
+func (s5 *Socks5Server) checkAccess(sess *session, target aclTarget) error {
+	acl := s5.Config.ACL
+	if acl == nil {
+		return nil
+	}
+	if sess != nil {
+		target.user = sess.userName()
+	}
+	allow, rule := acl.decide(target)
+	args := []any{"rule", rule, "user", target.user, "command", target.command, "domain", target.domain, "ip", target.ip, "port", target.port}
+	if sess != nil {
+		args = append(args, "remoteAddr", sess.clientAddr)
+	}
+	if !allow {
+		slog.Warn("acl deny", args...)
+		return fmt.Errorf("%w: %s", ErrAccessDenied, rule)
+	}
+	slog.Debug("acl allow", args...)
+	return nil
+}
+
+// newACLTarget 由请求地址 host:port 生成判断请求，host 为 IP 时同时作为实际连接的 IP
+func newACLTarget(command CommandType, address string) (aclTarget, error) {
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return aclTarget{}, err
+	}
+	target := aclTarget{command: command, port: port}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		target.ip = ip.Unmap()
+	} else {
+		target.domain = host
+	}
+	return target, nil
+}
@@ -0,0 +1,132 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func aclForTest(t *testing.T, content string) *ACL {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("write acl error %s", err)
+	}
+	acl, err := LoadACL(file)
+	if err != nil {
+		t.Fatalf("LoadACL error %s", err)
+	}
+	return acl
+}
+
+func TestACL_decide(t *testing.T) {
+	acl := aclForTest(t, `{"defaultAction": "deny", "rules": [
+		{"name": "no-smtp", "action": "deny", "ports": ["25"]},
+		{"name": "internal", "action": "deny", "networks": ["10.0.0.0/8", "fd00::/8"]},
+		{"name": "ops", "action": "allow", "networks": ["192.168.1.10"], "users": ["alice"]},
+		{"name": "web", "action": "allow", "domains": ["*.example.com", "example.org"], "ports": ["80", "443", "8000-8999"], "commands": ["connect"]},
+		{"action": "allow", "commands": ["udp"], "ports": ["53"]}
+	]}`)
+	tests := []struct {
+		name     string
+		target   aclTarget
+		want     bool
+		wantRule string
+	}{
+		{"wildcard domain", aclTarget{command: CommandConnect, domain: "www.example.com", port: 443}, true, "web"},
+		{"wildcard domain case and trailing dot", aclTarget{command: CommandConnect, domain: "API.Example.com.", port: 8080}, true, "web"},
+		{"wildcard excludes apex", aclTarget{command: CommandConnect, domain: "example.com", port: 443}, false, "default"},
+		{"exact domain", aclTarget{command: CommandConnect, domain: "example.org", port: 80}, true, "web"},
+		{"port out of range", aclTarget{command: CommandConnect, domain: "www.example.com", port: 9000}, false, "default"},
+		{"command not matched", aclTarget{command: CommandBind, domain: "www.example.com", port: 443}, false, "default"},
+		{"denied port first", aclTarget{command: CommandConnect, domain: "mail.example.com", port: 25}, false, "no-smtp"},
+		{"domain resolved to internal ip", aclTarget{command: CommandConnect, domain: "www.example.com", ip: netip.MustParseAddr("10.1.2.3"), port: 443}, false, "internal"},
+		{"ipv6 network", aclTarget{command: CommandConnect, ip: netip.MustParseAddr("fd12::1"), port: 443}, false, "internal"},
+		{"user matched", aclTarget{user: "alice", command: CommandConnect, ip: netip.MustParseAddr("192.168.1.10"), port: 22}, true, "ops"},
+		{"user not matched", aclTarget{user: "bob", command: CommandConnect, ip: netip.MustParseAddr("192.168.1.10"), port: 22}, false, "default"},
+		{"unnamed rule", aclTarget{command: CommandUdpAssociate, ip: netip.MustParseAddr("8.8.8.8"), port: 53}, true, "rule[4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rule := acl.decide(tt.target)
+			if got != tt.want || rule != tt.wantRule {
+				t.Fatalf("want get %v %s but got %v %s", tt.want, tt.wantRule, got, rule)
+			}
+		})
+	}
+}
+
+func TestACL_CompileInvalid(t *testing.T) {
+	for _, rule := range []ACLRule{
+		{Action: "drop"},
+		{Action: "deny", Networks: []string{"10.0.0.0/33"}},
+		{Action: "deny", Ports: []string{"90-80"}},
+		{Action: "deny", Ports: []string{"70000"}},
+		{Action: "deny", Commands: []string{"listen"}},
+		{Action: "deny", Domains: []string{"*"}},
+		{Action: "deny", Domains: []string{"a.*.com"}},
+	} {
+		acl := &ACL{Rules: []ACLRule{rule}}
+		if err := acl.Compile(); err == nil {
+			t.Fatalf("want get err for %+v but got nil", rule)
+		}
+	}
+	if err := (&ACL{DefaultAction: "block"}).Compile(); err == nil {
+		t.Fatalf("want get err for invalid defaultAction but got nil")
+	}
+}
+
+func TestSocks5Server_ACL(t *testing.T) {
+	target := echoForTest(t)
+	acl := &ACL{Rules: []ACLRule{
+		{Name: "admin-only", Action: "allow", Users: []string{"admin"}, Ports: []string{fmt.Sprint(target.Port)}},
+		{Name: "loopback", Action: "deny", Networks: []string{"127.0.0.0/8", "::1"}},
+	}}
+	if err := acl.Compile(); err != nil {
+		t.Fatalf("Compile error %s", err)
+	}
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, passwd string) bool {
+			return passwd == "123456"
+		},
+		ACL: acl,
+	}}
+	proxyAddr := serveForTest(t, s)
+
+	tests := []struct {
+		name    string
+		user    string
+		address string
+		want    ReplyType
+	}{
+		{"allowed user", "admin", target.String(), ReplySuccess},
+		{"other user denied", "bob", target.String(), ReplyRegularDenied},
+		// 域名解析到 127.0.0.1 后按实际连接的 IP 拒绝
+		{"domain resolved to denied ip", "bob", fmt.Sprintf("localhost:%d", target.Port), ReplyRegularDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &Dialer{ProxyAddr: proxyAddr, Username: tt.user, Passwd: "123456", Timeout: 5 * time.Second}
+			conn, err := dialer.Dial("tcp", tt.address)
+			got := ReplySuccess
+			var replyError *ReplyError
+			if errors.As(err, &replyError) {
+				got = replyError.Reply
+			} else if err != nil {
+				t.Fatalf("want get reply but got err %s", err)
+			} else {
+				conn.Close()
+			}
+			if got != tt.want {
+				t.Fatalf("want get reply %d but got %d", tt.want, got)
+			}
+		})
+	}
+}
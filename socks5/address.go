@@ -0,0 +1,107 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// appendAddress 按 ATYP DST.ADDR DST.PORT 的格式追加地址，IP 地址按 IPv4/IPv6 编码，其余按域名编码
+func appendAddress(buff []byte, host string, port uint16) ([]byte, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buff = append(buff, AddressTypeIPv4)
+			buff = append(buff, ip4...)
+		} else {
+			buff = append(buff, AddressTypeIPv6)
+			buff = append(buff, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("appendAddress invalid domain length %d", len(host))
+		}
+		buff = append(buff, AddressTypeDomain, byte(len(host)))
+		buff = append(buff, host...)
+	}
+	return binary.BigEndian.AppendUint16(buff, port), nil
+}
+
+// parseAddress 从 ATYP 开始解析地址，返回地址类型、host:port 形式的地址以及消耗的字节数
+func parseAddress(buff []byte) (AddressType, string, int, error) {
+	if len(buff) < 1 {
+		return 0, "", 0, errors.New("parseAddress short buffer")
+	}
+	addressType := buff[0]
+	var host string
+	n := 1
+	switch addressType {
+	case AddressTypeIPv4:
+		if len(buff) < n+net.IPv4len {
+			return 0, "", 0, errors.New("parseAddress short buffer")
+		}
+		host = net.IP(buff[n : n+net.IPv4len]).String()
+		n += net.IPv4len
+	case AddressTypeIPv6:
+		if len(buff) < n+net.IPv6len {
+			return 0, "", 0, errors.New("parseAddress short buffer")
+		}
+		host = net.IP(buff[n : n+net.IPv6len]).String()
+		n += net.IPv6len
+	case AddressTypeDomain:
+		if len(buff) < n+1 || len(buff) < n+1+int(buff[n]) {
+			return 0, "", 0, errors.New("parseAddress short buffer")
+		}
+		domainLen := int(buff[n])
+		host = string(buff[n+1 : n+1+domainLen])
+		n += 1 + domainLen
+	default:
+		return 0, "", 0, fmt.Errorf("parseAddress address type %d not supported", addressType)
+	}
+	if len(buff) < n+int(PortLen) {
+		return 0, "", 0, errors.New("parseAddress short buffer")
+	}
+	port := binary.BigEndian.Uint16(buff[n : n+int(PortLen)])
+	n += int(PortLen)
+	return addressType, net.JoinHostPort(host, strconv.Itoa(int(port))), n, nil
+}
+
+// readAddress 从连接中读取 ATYP DST.ADDR DST.PORT，返回地址类型和 host:port 形式的地址
+func readAddress(conn io.Reader) (AddressType, string, error) {
+	// 最长为域名：ATYP + 长度 + 255 字节域名 + 端口
+	buff := make([]byte, 1+1+255+int(PortLen))
+	if _, err := io.ReadFull(conn, buff[:2]); err != nil {
+		return 0, "", err
+	}
+	var total int
+	switch buff[0] {
+	case AddressTypeIPv4:
+		total = 1 + net.IPv4len + int(PortLen)
+	case AddressTypeIPv6:
+		total = 1 + net.IPv6len + int(PortLen)
+	case AddressTypeDomain:
+		total = 1 + 1 + int(buff[1]) + int(PortLen)
+	default:
+		return buff[0], "", fmt.Errorf("readAddress %w: %d", ErrAddressTypeNotSupported, buff[0])
+	}
+	if _, err := io.ReadFull(conn, buff[2:total]); err != nil {
+		return 0, "", err
+	}
+	addressType, address, _, err := parseAddress(buff[:total])
+	return addressType, address, err
+}
+
+// splitHostPort 拆分 host:port 形式的地址
+func splitHostPort(address string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("splitHostPort invalid port %q", portStr)
+	}
+	return host, uint16(port), nil
+}
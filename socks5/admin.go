@@ -0,0 +1,156 @@
+package socks5
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AdminHandler 管理接口，只应监听在本机或内网地址上
+//
+//	GET /lockouts 当前因认证失败过多被锁定的来源 IP 和用户名
+//	GET /ratelimits 当前的限速设置，PUT /ratelimits 整体替换限速设置，立即对已建立的连接生效
+//	GET /usage 每个用户的流量统计和配额
+//	GET /connections 并发连接数及排队、拒绝的统计
+//	GET /sessions 正在处理的会话，DELETE /sessions/{id} 断开会话，DELETE /users/{name}/sessions 断开用户的所有会话
+//
+// 所有接口都需 Authorization: Bearer <token>，未设置 Config.AdminToken 时所有接口都不可用
+func (s *Socks5Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		lockouts := []Lockout{}
+		if s.Config.AuthThrottle != nil {
+			lockouts = s.Config.AuthThrottle.Lockouts()
+		}
+		writeJSON(w, lockouts)
+	})
+	mux.HandleFunc("/ratelimits", func(w http.ResponseWriter, r *http.Request) {
+		limiter := s.Config.RateLimiter
+		if limiter == nil {
+			http.Error(w, "rate limit not enabled", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var limits RateLimits
+			if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			limiter.SetLimits(limits)
+			slog.Info("rate limits updated", "limits", limits)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, limiter.Limits())
+	})
+	mux.HandleFunc("/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		usages := []UserUsage{}
+		if s.Config.Accounting != nil {
+			usages = s.Config.Accounting.Usages()
+		}
+		writeJSON(w, usages)
+	})
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Config.ConnLimiter == nil {
+			http.Error(w, "connection limit not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s.Config.ConnLimiter.Stats())
+	})
+	mux.HandleFunc("/sessions", s.adminSessions(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, s.Config.Sessions.List())
+	}))
+	mux.HandleFunc("/sessions/", s.adminSessions(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/sessions/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid session id", http.StatusBadRequest)
+			return
+		}
+		if !s.Config.Sessions.Kill(id) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]int{"killed": 1})
+	}))
+	mux.HandleFunc("/users/", s.adminSessions(func(w http.ResponseWriter, r *http.Request) {
+		// 只有 /users/{name}/sessions，name 需经过 URL 编码
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/users/"), "/sessions")
+		if !ok || name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := url.PathUnescape(name)
+		if err != nil {
+			http.Error(w, "invalid user name", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]int{"killed": s.Config.Sessions.KillUser(user)})
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.AdminToken == "" {
+			http.Error(w, "admin token required", http.StatusForbidden)
+			return
+		}
+		if !adminTokenValid(r, s.Config.AdminToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="socks5 admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminSessions 会话相关的接口需开启会话登记
+func (s *Socks5Server) adminSessions(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.Sessions == nil {
+			http.Error(w, "session registry not enabled", http.StatusNotFound)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// adminTokenValid 常量时间比较 Bearer Token
+func adminTokenValid(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("admin write response failed", "err", err)
+	}
+}
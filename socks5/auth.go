@@ -82,42 +82,33 @@ func NewUserPasswdReplyMessage(conn io.Writer, status byte) error {
 
 }
 
-// 协商认证
-func auth(conn net.Conn, config *Config, reader *bufio.Reader) error {
+// 协商认证，返回认证得到的身份（用户名或 TLS 客户端证书的身份），无需认证时为空
+// certIdentity 不为空表示身份已由 TLS 客户端证书确定，客户端提供了无需认证时直接选择无需认证
+// 返回错误时调用方必须关闭连接，不能继续处理请求：没有可接受的认证方式时为 ErrNoAcceptableMethod，认证失败时为 ErrAuthFailed
+func auth(conn net.Conn, config *Config, reader *bufio.Reader, certIdentity string) (string, error) {
 	authMessage, err := NewAuthMessageFromClient(reader)
 	if err != nil {
-		return err
-	}
-	if authMessage != nil {
-		supportedMethod := bytes.IndexByte(authMessage.Methods, config.Method) >= 0
-		if !supportedMethod {
-			// Server选择一个自己也支持的认证方案
-			ServerChooseOneSupportedMethodToClient(conn, MethodNotSupported)
-			return err
-		}
-		// Server选择一个自己也支持的认证方案
-		err := ServerChooseOneSupportedMethodToClient(conn, config.Method)
-		if err != nil {
-			return err
-		}
-		//子协商
-		if config.Method == MethodUserPasswd {
-			userPasswdAuthMessage, err := NewUserPasswdMessage(conn)
-			if err != nil {
-				return err
-			}
-			userName := userPasswdAuthMessage.UserName
-			passwd := userPasswdAuthMessage.Passwd
-			passed := config.CheckAuthFunc(userName, passwd)
-			if !passed {
-				NewUserPasswdReplyMessage(conn, UserPasswdAuthFail)
-			} else {
-				NewUserPasswdReplyMessage(conn, UserPasswdAuthSuccess)
-			}
-
-		}
+		return "", err
 	}
-	return err
+	if certIdentity != "" && bytes.IndexByte(authMessage.Methods, MethodNoAuth) >= 0 {
+		config.Metrics.authResult("tlscert", nil)
+		return certIdentity, ServerChooseOneSupportedMethodToClient(conn, MethodNoAuth)
+	}
+	// Server按优先级选择一个自己也支持的认证方案
+	authenticator := config.selectAuthenticator(authMessage.Methods, conn.RemoteAddr())
+	if authenticator == nil {
+		ServerChooseOneSupportedMethodToClient(conn, MethodNotSupported)
+		err := fmt.Errorf("%w: client offered %v", ErrNoAcceptableMethod, authMessage.Methods)
+		config.Metrics.authResult("none", err)
+		return "", err
+	}
+	if err := ServerChooseOneSupportedMethodToClient(conn, authenticator.Method()); err != nil {
+		return "", err
+	}
+	//子协商
+	user, err := authenticator.Authenticate(conn)
+	config.Metrics.authResult(methodLabel(authenticator.Method()), err)
+	return user, err
 }
 
 // 选择认证方式并认证
@@ -130,7 +121,39 @@ func (s5 *Socks5Server) clientAuth(conn io.ReadWriter, method MethodType) (err e
 		slog.Error("auth not supported method")
 		return errors.New("not supported method")
 	}
-	var totalBuff [8]byte
+	return clientUserPasswdAuth(conn, s5.Config.Username, s5.Config.Passwd)
+}
+
+// clientNegotiate 客户端发送支持的认证方式，返回服务端选择的认证方式
+func clientNegotiate(conn io.ReadWriter, methods ...MethodType) (MethodType, error) {
+	buff := []byte{Socks5, byte(len(methods))}
+	buff = append(buff, methods...)
+	if _, err := conn.Write(buff); err != nil {
+		slog.Error("发送认证请求失败", "err", err)
+		return MethodNotSupported, fmt.Errorf("<negotiate write err> %w ", err)
+	}
+	// 接收soccks5 认证响应
+	readBuff := make([]byte, 2)
+	if _, err := io.ReadFull(conn, readBuff); err != nil {
+		slog.Error("读取认证响应失败", "err", err)
+		return MethodNotSupported, fmt.Errorf("<negotiate readFull err> %w ", err)
+	}
+	if readBuff[0] != Socks5 {
+		return MethodNotSupported, errors.New("<negotiate protocol not supported>")
+	}
+	method := readBuff[1]
+	if method == MethodNotSupported || bytes.IndexByte(methods, method) < 0 {
+		slog.Error("无可用的认证方式", "method", method)
+		return method, fmt.Errorf("<negotiate> %w", ErrNoAcceptableMethod)
+	}
+	return method, nil
+}
+
+// clientUserPasswdAuth 客户端用户名密码子协商
+func clientUserPasswdAuth(conn io.ReadWriter, username, passwd string) (err error) {
+	if len(username) == 0 || len(username) > 255 || len(passwd) > 255 {
+		return errors.New("<auth invalid username or passwd length>")
+	}
 	// 客户端发送验证数据包 （鉴定协议版本目前为 0x01 ）
 	// +-----+-----------------+----------+-----------------+----------+
 	// | VER | USERNAME_LENGTH | USERNAME | PASSWORD_LENGTH | PASSWORD |
@@ -141,10 +164,10 @@ func (s5 *Socks5Server) clientAuth(conn io.ReadWriter, method MethodType) (err e
 	var buff []byte
 	// 版本
 	buff = append(buff, UserPasswdAuthVer)
-	buff = append(buff, byte(len(s5.Config.Username)))
-	buff = append(buff, []byte(s5.Config.Username)...)
-	buff = append(buff, byte(len(s5.Config.Passwd)))
-	buff = append(buff, []byte(s5.Config.Passwd)...)
+	buff = append(buff, byte(len(username)))
+	buff = append(buff, []byte(username)...)
+	buff = append(buff, byte(len(passwd)))
+	buff = append(buff, []byte(passwd)...)
 	if _, err = conn.Write(buff); err != nil {
 		slog.Error("auth write failed")
 		return fmt.Errorf("<auth write err> %w ", err)
@@ -156,16 +179,16 @@ func (s5 *Socks5Server) clientAuth(conn io.ReadWriter, method MethodType) (err e
 	// +-----+--------+
 	// |   1 |      1 |
 	// +-----+--------+
-	readBuff := totalBuff[:2]
+	readBuff := make([]byte, 2)
 	if _, err = io.ReadFull(conn, readBuff); err != nil {
 		slog.Error("auth readFull failed")
 		return errors.New("<auth readFull failed>")
 	}
 
 	// 认证失败
-	if readBuff[1] != ReplySuccess {
+	if readBuff[0] != UserPasswdAuthVer || readBuff[1] != UserPasswdAuthSuccess {
 		slog.Error("auth failed")
-		return errors.New("<auth failed>")
+		return fmt.Errorf("<auth> %w", ErrAuthFailed)
 	}
 	slog.Debug("clinet auth success")
 	return nil
@@ -2,9 +2,14 @@ package socks5
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSocks5Server_Run(t *testing.T) {
@@ -42,3 +47,90 @@ func TestNewAuthMessage(t *testing.T) {
 
 	})
 }
+
+// TestAuth_FailClosed 认证失败或没有可接受的认证方式时返回对应错误并关闭连接，请求不会被处理
+func TestAuth_FailClosed(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen error %s", err)
+	}
+	defer target.Close()
+	targetAddr := target.Addr().(*net.TCPAddr)
+	connect := []byte{Socks5, CommandConnect, RSV, AddressTypeIPv4}
+	connect = append(connect, targetAddr.IP.To4()...)
+	connect = binary.BigEndian.AppendUint16(connect, uint16(targetAddr.Port))
+
+	userPasswd := func(userName, passwd string) []byte {
+		buff := []byte{UserPasswdAuthVer, byte(len(userName))}
+		buff = append(buff, userName...)
+		buff = append(buff, byte(len(passwd)))
+		return append(buff, passwd...)
+	}
+	tests := []struct {
+		name      string
+		send      []byte
+		wantReply []byte
+		wantErr   error
+	}{
+		{"wrong passwd",
+			append(append([]byte{Socks5, 1, MethodUserPasswd}, userPasswd("admin", "wrong")...), connect...),
+			[]byte{Socks5, MethodUserPasswd, UserPasswdAuthVer, UserPasswdAuthFail}, ErrAuthFailed},
+		{"unknown user",
+			append(append([]byte{Socks5, 1, MethodUserPasswd}, userPasswd("nobody", "123456")...), connect...),
+			[]byte{Socks5, MethodUserPasswd, UserPasswdAuthVer, UserPasswdAuthFail}, ErrAuthFailed},
+		{"no acceptable method",
+			append([]byte{Socks5, 1, MethodNoAuth}, connect...),
+			[]byte{Socks5, MethodNotSupported}, ErrNoAcceptableMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Socks5Server{IsServer: true, Config: Config{
+				AllowPrivateDestinations: true,
+				Method:                   MethodUserPasswd,
+				Timeout:                  time.Second,
+				CheckAuthFunc: func(userName, passwd string) bool {
+					return userName == "admin" && passwd == "123456"
+				},
+			}}
+			listen, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.Listen error %s", err)
+			}
+			defer listen.Close()
+			errCh := make(chan error, 1)
+			go func() {
+				conn, err := listen.Accept()
+				if err != nil {
+					errCh <- err
+					return
+				}
+				errCh <- s.handleConn(conn, &s.Config)
+			}()
+
+			conn, err := net.Dial("tcp", listen.Addr().String())
+			if err != nil {
+				t.Fatalf("net.Dial error %s", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write(tt.send)
+			// 服务端只回复协商结果，随后关闭连接，不会回复 CONNECT 请求
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("want get EOF but got %s", err)
+			}
+			if !bytes.Equal(got, tt.wantReply) {
+				t.Fatalf("want get %v but got %v", tt.wantReply, got)
+			}
+			if err := <-errCh; !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want get %v but got %v", tt.wantErr, err)
+			}
+			// handleConn 已返回，目标地址不应收到任何连接
+			target.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+			if targetConn, err := target.Accept(); err == nil {
+				targetConn.Close()
+				t.Fatalf("want get no connection to target but got one")
+			}
+		})
+	}
+}
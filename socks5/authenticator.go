@@ -0,0 +1,163 @@
+package socks5
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Authenticator 一种认证方式：方法码、子协商，以及认证得到的身份
+type Authenticator interface {
+	// Method 协商时回复给客户端的方法码
+	Method() MethodType
+	// Authenticate 选定该方式后进行子协商，返回认证得到的身份，无需认证时为空
+	Authenticate(conn net.Conn) (string, error)
+}
+
+// SourceFilter Authenticator 可选实现，只对 AllowSource 返回 true 的客户端提供该认证方式
+type SourceFilter interface {
+	AllowSource(addr net.Addr) bool
+}
+
+// PasswdChecker Authenticator 可选实现，HTTP 代理的 Proxy-Authorization 借此校验用户名密码
+type PasswdChecker interface {
+	// CheckPasswd 校验来自 addr 的用户名密码，失败时返回 ErrAuthFailed（或包装了它的错误）
+	CheckPasswd(addr net.Addr, userName, passwd string) error
+}
+
+// SourceNetworks 限制认证方式只对这些网络的客户端提供，为空时不限制
+type SourceNetworks []netip.Prefix
+
+func (n SourceNetworks) AllowSource(addr net.Addr) bool {
+	if len(n) == 0 {
+		return true
+	}
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range n {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NoAuthAuthenticator 无需认证
+type NoAuthAuthenticator struct {
+	SourceNetworks
+}
+
+func (a *NoAuthAuthenticator) Method() MethodType {
+	return MethodNoAuth
+}
+
+func (a *NoAuthAuthenticator) Authenticate(conn net.Conn) (string, error) {
+	return "", nil
+}
+
+// UserPasswdAuthenticator 用户名密码认证（RFC 1929）
+type UserPasswdAuthenticator struct {
+	SourceNetworks
+	CheckAuthFunc func(userName string, passwd string) bool
+	// Throttle 不为 nil 时按来源 IP 和用户名限制连续失败
+	Throttle *AuthThrottle
+}
+
+func (a *UserPasswdAuthenticator) Method() MethodType {
+	return MethodUserPasswd
+}
+
+func (a *UserPasswdAuthenticator) Authenticate(conn net.Conn) (string, error) {
+	userPasswdAuthMessage, err := NewUserPasswdMessage(conn)
+	if err != nil {
+		return "", err
+	}
+	userName := userPasswdAuthMessage.UserName
+	if err := a.CheckPasswd(conn.RemoteAddr(), userName, userPasswdAuthMessage.Passwd); err != nil {
+		NewUserPasswdReplyMessage(conn, UserPasswdAuthFail)
+		return "", err
+	}
+	return userName, NewUserPasswdReplyMessage(conn, UserPasswdAuthSuccess)
+}
+
+func (a *UserPasswdAuthenticator) CheckPasswd(addr net.Addr, userName, passwd string) error {
+	var ip string
+	if a.Throttle != nil {
+		if addr, ok := addrIP(addr); ok {
+			ip = addr.String()
+		}
+		delay, err := a.Throttle.Allow(ip, userName)
+		if err != nil {
+			slog.Warn("auth rejected, locked out", "user", userName, "ip", ip)
+			return fmt.Errorf("%w: user %q", err, userName)
+		}
+		time.Sleep(delay)
+	}
+	if a.CheckAuthFunc == nil || !a.CheckAuthFunc(userName, passwd) {
+		if a.Throttle != nil {
+			a.Throttle.Failure(ip, userName)
+		}
+		return fmt.Errorf("%w: user %q", ErrAuthFailed, userName)
+	}
+	if a.Throttle != nil {
+		a.Throttle.Success(userName)
+	}
+	return nil
+}
+
+// authenticators 按优先级排列的认证方式，未设置 Authenticators 时由 Method 和 CheckAuthFunc 生成
+func (c *Config) authenticators() []Authenticator {
+	if len(c.Authenticators) > 0 {
+		return c.Authenticators
+	}
+	switch c.Method {
+	case MethodNoAuth:
+		return []Authenticator{&NoAuthAuthenticator{}}
+	case MethodUserPasswd:
+		return []Authenticator{&UserPasswdAuthenticator{CheckAuthFunc: c.CheckAuthFunc, Throttle: c.AuthThrottle}}
+	}
+	return nil
+}
+
+// selectAuthenticator 按优先级选择第一个客户端提供了、且对该客户端开放的认证方式
+func (c *Config) selectAuthenticator(methods []MethodType, addr net.Addr) Authenticator {
+	for _, authenticator := range c.authenticators() {
+		if bytes.IndexByte(methods, authenticator.Method()) < 0 {
+			continue
+		}
+		if sourceAllowed(authenticator, addr) {
+			return authenticator
+		}
+	}
+	return nil
+}
+
+// noAuthAllowed 该客户端是否可以无需认证，用于没有认证子协商的 SOCKS4
+func (c *Config) noAuthAllowed(addr net.Addr) bool {
+	return c.selectAuthenticator([]MethodType{MethodNoAuth}, addr) != nil
+}
+
+func sourceAllowed(authenticator Authenticator, addr net.Addr) bool {
+	filter, ok := authenticator.(SourceFilter)
+	return !ok || filter.AllowSource(addr)
+}
+
+// addrIP 取出地址中的 IP，IPv4-mapped IPv6 地址转为 IPv4
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	ipAddr, ok := netip.AddrFromSlice(ip)
+	return ipAddr.Unmap(), ok
+}
@@ -0,0 +1,69 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestConfig_selectAuthenticator(t *testing.T) {
+	noAuth := &NoAuthAuthenticator{SourceNetworks: SourceNetworks{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}}
+	userPasswd := &UserPasswdAuthenticator{}
+	config := Config{Authenticators: []Authenticator{noAuth, userPasswd}}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	localMapped := &net.TCPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 1234}
+	remote := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 1234}
+
+	tests := []struct {
+		name    string
+		methods []MethodType
+		addr    net.Addr
+		want    Authenticator
+	}{
+		{"local offers both", []MethodType{MethodUserPasswd, MethodNoAuth}, local, noAuth},
+		{"ipv4-mapped local offers both", []MethodType{MethodUserPasswd, MethodNoAuth}, localMapped, noAuth},
+		{"local offers passwd only", []MethodType{MethodUserPasswd}, local, userPasswd},
+		{"remote offers both", []MethodType{MethodNoAuth, MethodUserPasswd}, remote, userPasswd},
+		{"remote offers no auth only", []MethodType{MethodNoAuth}, remote, nil},
+		{"gssapi only", []MethodType{MethodGssApi}, local, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.selectAuthenticator(tt.methods, tt.addr); got != tt.want {
+				t.Fatalf("want get %v but got   %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("legacy Method config", func(t *testing.T) {
+		config := Config{Method: MethodUserPasswd}
+		got := config.selectAuthenticator([]MethodType{MethodNoAuth, MethodUserPasswd}, remote)
+		if got == nil || got.Method() != MethodUserPasswd {
+			t.Fatalf("want get MethodUserPasswd but got   %v", got)
+		}
+	})
+}
+
+func TestSocks5Server_Authenticators(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Timeout:                  time.Second,
+		Authenticators: []Authenticator{
+			&NoAuthAuthenticator{SourceNetworks: SourceNetworks{netip.MustParsePrefix("10.0.0.0/8")}},
+			&UserPasswdAuthenticator{CheckAuthFunc: func(userName, password string) bool { return true }},
+		},
+	}}
+	conn, err := net.Dial("tcp", serveForTest(t, s))
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{Socks5, 2, MethodNoAuth, MethodUserPasswd})
+	buff := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != MethodUserPasswd {
+		t.Fatalf("want get MethodUserPasswd but got %v %s", buff, err)
+	}
+}
@@ -0,0 +1,108 @@
+package socks5
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// replyFunc 按协议版本回复请求结果
+type replyFunc func(replyType ReplyType, bindAddr net.Addr) error
+
+// handleBind BIND: 监听端口等待目标主机回连，第一次回复监听地址，收到来自 DST.ADDR 的连接后第二次回复对端地址并转发
+func (s5 *Socks5Server) handleBind(conn net.Conn, sess *session, message *RequestMessage) error {
+	return s5.bind(conn, sess, message.Address, func(replyType ReplyType, bindAddr net.Addr) error {
+		_, err := NewReplyMessage(replyType, bindAddr).WriteTo(conn)
+		return err
+	})
+}
+
+// bind BIND 的处理过程，SOCKS4 和 SOCKS5 共用，回复由 reply 按各自的格式写回
+func (s5 *Socks5Server) bind(conn net.Conn, sess *session, address string, reply replyFunc) error {
+	target, err := newACLTarget(CommandBind, address)
+	if err != nil {
+		reply(ReplyCommonFail, nil)
+		return err
+	}
+	if err := s5.checkAccess(sess, target); err != nil {
+		reply(ReplyRegularDenied, nil)
+		return err
+	}
+	if err := s5.checkQuota(sess); err != nil {
+		reply(ReplyRegularDenied, nil)
+		return err
+	}
+	sess.setTarget(address)
+	var bindIP net.IP
+	if localAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		bindIP = localAddr.IP
+	}
+	listen, err := net.ListenTCP("tcp", &net.TCPAddr{IP: bindIP})
+	if err != nil {
+		slog.Error("net.ListenTCP error", "err", err)
+		reply(ReplyCommonFail, nil)
+		return err
+	}
+	defer listen.Close()
+	if err := reply(ReplySuccess, listen.Addr()); err != nil {
+		return err
+	}
+	slog.Debug("bind listen", "bindAddr", listen.Addr(), "dstAddr", address)
+
+	timeout := s5.Config.BindTimeout
+	if timeout == 0 {
+		timeout = s5.Config.Timeout
+	}
+	if timeout > 0 {
+		listen.SetDeadline(time.Now().Add(timeout))
+	}
+	peerConn, err := listen.AcceptTCP()
+	if err != nil {
+		slog.Error("bind accept error", "bindAddr", listen.Addr(), "err", err)
+		reply(dialErrorToReply(err), nil)
+		return err
+	}
+	listen.Close()
+
+	if !bindPeerAllowed(address, peerConn.RemoteAddr().(*net.TCPAddr).IP) {
+		slog.Error("bind peer not allowed", "dstAddr", address, "peerAddr", peerConn.RemoteAddr())
+		peerConn.Close()
+		reply(ReplyRegularDenied, nil)
+		return fmt.Errorf("%w: bind peer %s", ErrAccessDenied, peerConn.RemoteAddr())
+	}
+	// DST.ADDR 为全零地址时回连的主机不受限制，按实际回连的 IP 再判断一次
+	target.ip = peerConn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr().Unmap()
+	sess.setResolvedIP(target.ip.String())
+	if err := s5.checkAccess(sess, target); err != nil {
+		peerConn.Close()
+		reply(ReplyRegularDenied, nil)
+		return err
+	}
+	if err := reply(ReplySuccess, peerConn.RemoteAddr()); err != nil {
+		peerConn.Close()
+		return err
+	}
+	return s5.forward(sess, conn, peerConn)
+}
+
+// bindPeerAllowed 回连的主机必须是请求中的 DST.ADDR，DST.ADDR 为全零地址时不做限制
+func bindPeerAllowed(address string, peerIP net.IP) bool {
+	host, _, err := splitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsUnspecified() || ip.Equal(peerIP)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(peerIP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// bindForTest 完成协商并发送 BIND 请求，返回第一次回复中的监听地址
+func bindForTest(t *testing.T, address string, dstIP net.IP) (net.Conn, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{Socks5, 1, MethodNoAuth})
+	buff := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != MethodNoAuth {
+		t.Fatalf("auth failed %v %s", buff, err)
+	}
+	request := append([]byte{Socks5, CommandBind, RSV, AddressTypeIPv4}, dstIP.To4()...)
+	conn.Write(append(request, 0, 0))
+	buff = make([]byte, 10)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != ReplySuccess {
+		t.Fatalf("first bind reply failed %v %s", buff, err)
+	}
+	_, bindAddress, _, err := parseAddress(buff[3:])
+	if err != nil {
+		t.Fatalf("parseAddress error %s", err)
+	}
+	return conn, bindAddress
+}
+
+func TestSocks5Server_Bind(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: 5 * time.Second}}
+	address := serveForTest(t, s)
+
+	t.Run("test bind should relay peer connection", func(t *testing.T) {
+		conn, bindAddress := bindForTest(t, address, net.IPv4(127, 0, 0, 1))
+		peerConn, err := net.Dial("tcp", bindAddress)
+		if err != nil {
+			t.Fatalf("net.Dial bindAddress error %s", err)
+		}
+		defer peerConn.Close()
+
+		buff := make([]byte, 10)
+		if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != ReplySuccess {
+			t.Fatalf("second bind reply failed %v %s", buff, err)
+		}
+		_, peerAddress, _, _ := parseAddress(buff[3:])
+		if peerAddress != peerConn.LocalAddr().String() {
+			t.Fatalf("want get peer address %s but got %s", peerConn.LocalAddr(), peerAddress)
+		}
+		peerConn.Write([]byte("ping"))
+		buff = make([]byte, 4)
+		if _, err := io.ReadFull(conn, buff); err != nil || string(buff) != "ping" {
+			t.Fatalf("want get ping but got %q %s", buff, err)
+		}
+	})
+
+	t.Run("test bind from unexpected host should be denied", func(t *testing.T) {
+		conn, bindAddress := bindForTest(t, address, net.IPv4(127, 0, 0, 2))
+		peerConn, err := net.Dial("tcp", bindAddress)
+		if err != nil {
+			t.Fatalf("net.Dial bindAddress error %s", err)
+		}
+		defer peerConn.Close()
+
+		buff := make([]byte, 10)
+		if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != ReplyRegularDenied {
+			t.Fatalf("want get ReplyRegularDenied but got %v %s", buff, err)
+		}
+	})
+}
@@ -1,81 +1,182 @@
 package socks5
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
 	"log/slog"
 	"net"
-	"os"
+	"sync"
+	"time"
 )
 
 type Client struct {
 	Username, Passwd, RemoteAddr, Addr string
+	// TLS 不为 nil 时使用 TLS 连接远程服务端
+	TLS *TLSClientConfig
+	// ConnLimiter 并发连接数上限（全局和每个客户端 IP），为 nil 时不限制
+	ConnLimiter *ConnLimiter
+	// Metrics 监控指标，为 nil 时不记录
+	Metrics *Metrics
+
+	tlsOnce   sync.Once
+	tlsConfig *tls.Config
+	tlsErr    error
+	graceful  graceful
+}
+
+// Run 监听 Addr 并处理连接，直到调用 Shutdown
+func (c *Client) Run() error {
+	return c.ListenAndServe(context.Background())
 }
 
-func (c *Client) Run() {
+// ListenAndServe 监听 Addr 并处理连接，ctx 取消时停止接受新连接
+func (c *Client) ListenAndServe(ctx context.Context) error {
 	listen, err := net.Listen("tcp", c.Addr)
 	if err != nil {
 		slog.Error("listen failed", "err", err)
-		os.Exit(1)
+		return err
 	}
-	defer listen.Close()
+	return c.Serve(ctx, listen)
+}
+
+// Serve 在 listen 上接受连接并转发到远程服务端；
+// ctx 取消时返回 ctx.Err()，调用 Shutdown 后返回 ErrServerClosed，已建立的连接不受 ctx 影响
+func (c *Client) Serve(ctx context.Context, listen net.Listener) error {
+	queueCtx, stop, err := c.graceful.start(ctx, listen)
+	if err != nil {
+		return err
+	}
+	defer stop()
 	for {
-		clientConn, err := listen.Accept()
+		clientConn, err := c.graceful.accept(ctx, listen)
+		if err != nil {
+			return err
+		}
+		c.Metrics.acceptedConn()
+		// 全局并发上限在此排队，期间不接受新连接；ctx 取消或 Shutdown 时停止排队
+		releaseGlobal, err := c.ConnLimiter.admitGlobal(queueCtx, clientConn)
 		if err != nil {
-			slog.Error("listen.Accept  failed", "err", err)
+			c.Metrics.rejectedConn("conn_limit")
+			clientConn.Close()
 			continue
 		}
-		go c.handleClientConn(clientConn)
+		done := c.graceful.trackConn(clientConn)
+		if done == nil {
+			releaseGlobal()
+			clientConn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer done()
+			defer releaseGlobal()
+			defer c.Metrics.sessionStarted()()
+			releaseIP, err := c.ConnLimiter.admitIP(clientConn)
+			if err != nil {
+				c.Metrics.rejectedConn("conn_limit")
+				clientConn.Close()
+				return
+			}
+			defer releaseIP()
+			c.handleClientConn(clientConn)
+		}()
 	}
+}
 
+// Shutdown 停止接受新连接，等待正在转发的连接结束；ctx 结束时强制关闭剩余连接并返回 ctx.Err()
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.graceful.shutdown(ctx)
 }
 
 func (c *Client) handleClientConn(clientConn net.Conn) {
 	defer clientConn.Close()
 
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
+	// 解析客户端的协商请求（这些字节要在本地服务端消费掉，不能在后续copy中传递）
+	// 使用 bufio 读取，协商和请求被拆分或合并在一次 TCP 读取中都能正确处理
+	reader := bufio.NewReader(clientConn)
+	authMessage, err := NewAuthMessageFromClient(reader)
 	if err != nil {
-		slog.Error("读取客户端请求失败", "err", err)
+		slog.Error("读取客户端协商请求失败", "err", err)
 		return
 	}
-	// 解析客户端请求（这些字节要再本地服务端消费掉，不能在后续copy中传递）
-	if n < 3 || buf[0] != Socks5 || buf[1] != 0x01 || buf[2] != 0x00 {
-		slog.Error("无法识别的请求")
+	// 本地服务端只对客户端提供无需认证
+	if bytes.IndexByte(authMessage.Methods, MethodNoAuth) < 0 {
+		slog.Error("客户端不支持无需认证", "methods", authMessage.Methods)
+		ServerChooseOneSupportedMethodToClient(clientConn, MethodNotSupported)
 		return
 	}
 
-	remoteConn, err := net.Dial("tcp", c.RemoteAddr)
+	start := time.Now()
+	remoteConn, err := c.dialRemote()
+	c.Metrics.dialed(time.Since(start))
 	if err != nil {
 		slog.Error("连接远程服务端失败", "RemoteAddr", c.RemoteAddr, "err", err)
+		ServerChooseOneSupportedMethodToClient(clientConn, MethodNotSupported)
 		return
 	}
 	defer remoteConn.Close()
 
 	// 请求远程服务端，重新模拟客户端的socks5认证（重点是改写添加密码认证）等，且从远程传过来的认证数据也要在本地服务端消费刁
 	if !c.socks5AuthByUserPasswd(remoteConn) {
+		c.Metrics.handshake("socks5", ErrAuthFailed)
 		slog.Error("远程服务端认证失败", "RemoteAddr", c.RemoteAddr)
+		ServerChooseOneSupportedMethodToClient(clientConn, MethodNotSupported)
 		return
 	}
+	c.Metrics.handshake("socks5", nil)
 	// 给客户端（浏览器）回写不需要认证的回复，本地服务端的回复，浏览器不支持
-	_, err = clientConn.Write([]byte{Socks5, MethodNoAuth})
+	err = ServerChooseOneSupportedMethodToClient(clientConn, MethodNoAuth)
 	if err != nil {
 		slog.Error("给客户端回写不需要认证失败", "err", err)
 		return
 	}
 	// 伪造的认证阶段结束
-	// 后续是流量的正常转发过程，包过socks5的请求阶段
+	// 请求阶段：解析客户端的请求后重新编码发给远程服务端，远程服务端的回复原样返回
+	requestMessage, err := NewRequestMessageFromClient(reader)
+	if err != nil {
+		slog.Error("读取客户端请求失败", "err", err)
+		return
+	}
+	buff, err := requestMessage.Bytes()
+	if err != nil {
+		slog.Error("编码客户端请求失败", "err", err)
+		return
+	}
+	if _, err := remoteConn.Write(buff); err != nil {
+		slog.Error("发送请求到远程服务端失败", "RemoteAddr", c.RemoteAddr, "err", err)
+		return
+	}
+	// 开启监控时读取 CONNECT 的回复以记录回复码，再原样写回客户端
+	if c.Metrics != nil && requestMessage.Command == CommandConnect {
+		reply, err := NewReplyMessageFromServer(remoteConn)
+		if err != nil {
+			slog.Error("读取远程服务端回复失败", "RemoteAddr", c.RemoteAddr, "err", err)
+			return
+		}
+		c.Metrics.connectResult(reply.Reply)
+		if _, err := reply.WriteTo(clientConn); err != nil || reply.Reply != ReplySuccess {
+			return
+		}
+	}
+	// 后续是流量的正常转发过程，reader 中可能已经缓存了客户端的数据
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	var upload, download io.Reader = reader, remoteConn
+	if c.Metrics != nil {
+		upload = &countingReader{ctx: ctx, reader: upload, count: c.Metrics.countBytes("upload")}
+		download = &countingReader{ctx: ctx, reader: download, count: c.Metrics.countBytes("download")}
+	}
 	go func() {
-		_, err := io.Copy(remoteConn, clientConn)
+		_, err := io.Copy(remoteConn, upload)
 		if err != nil {
 			slog.Debug("from  clientConn copy to remoteConn failed", "RemoteAddr", c.RemoteAddr, "err", err)
 		}
 		cancel()
 	}()
 	go func() {
-		_, err = io.Copy(clientConn, remoteConn)
+		_, err := io.Copy(clientConn, download)
 		if err != nil {
 			slog.Debug("from  remoteConn copy to clientConn failed", "RemoteAddr", c.RemoteAddr, "err", err)
 		}
@@ -85,54 +186,43 @@ func (c *Client) handleClientConn(clientConn net.Conn) {
 
 }
 
+// dialRemote 连接远程服务端，设置了 TLS 时使用 TLS
+func (c *Client) dialRemote() (net.Conn, error) {
+	if c.TLS == nil {
+		return net.Dial("tcp", c.RemoteAddr)
+	}
+	c.tlsOnce.Do(func() {
+		c.tlsConfig, c.tlsErr = c.TLS.NewTLSConfig(c.RemoteAddr)
+	})
+	if c.tlsErr != nil {
+		return nil, c.tlsErr
+	}
+	return tls.Dial("tcp", c.RemoteAddr, c.tlsConfig)
+}
+
 func (c *Client) socks5AuthByUserPasswd(conn net.Conn) bool {
+	// 未设置用户名时与远程服务端协商无需认证
 	if c.Username == "" {
+		method, err := clientNegotiate(conn, MethodNoAuth)
+		if err != nil || method != MethodNoAuth {
+			slog.Error("无法进行无需认证", "err", err)
+			c.Metrics.authResult(methodLabel(MethodNoAuth), ErrNoAcceptableMethod)
+			return false
+		}
+		c.Metrics.authResult(methodLabel(MethodNoAuth), nil)
 		return true
 	}
 	// socks5认证请求
-	_, err := conn.Write([]byte{Socks5, 1, MethodUserPasswd})
-	if err != nil {
-		slog.Error("发送认证请求失败", "err", err)
-		return false
-	}
-	// 接收soccks5 认证响应
-	buf := make([]byte, 2)
-	_, err = io.ReadFull(conn, buf)
-	if err != nil {
-		slog.Error("读取认证响应失败", "err", err)
-		return false
-	}
-	// 检查认证响应
-	if buf[0] != Socks5 || buf[1] != MethodUserPasswd {
-		slog.Error("无法进行用户名密码认证")
+	method, err := clientNegotiate(conn, MethodUserPasswd)
+	if err != nil || method != MethodUserPasswd {
+		slog.Error("无法进行用户名密码认证", "err", err)
+		c.Metrics.authResult(methodLabel(MethodUserPasswd), ErrNoAcceptableMethod)
 		return false
 	}
 	// 客户端发送密码验证数据包 （鉴定协议版本目前为 0x01,UserPasswdAuthVer ）
-	// +-----+-----------------+----------+-----------------+----------+
-	// | VER | USERNAME_LENGTH | USERNAME | PASSWORD_LENGTH | PASSWORD |
-	// +-----+-----------------+----------+-----------------+----------+
-	// |   1 |               1 | 1-255    |               1 | 1-255    |
-	// +-----+-----------------+----------+-----------------+----------+
-	usernameBytes := []byte(c.Username)
-	passwdBytes := []byte(c.Passwd)
-	var userPassBuff []byte
-	userPassBuff = append(userPassBuff, UserPasswdAuthVer)
-	userPassBuff = append(userPassBuff, byte(len(usernameBytes)))
-	userPassBuff = append(userPassBuff, usernameBytes...)
-	userPassBuff = append(userPassBuff, byte(len(passwdBytes)))
-	userPassBuff = append(userPassBuff, passwdBytes...)
-	_, err = conn.Write(userPassBuff)
+	err = clientUserPasswdAuth(conn, c.Username, c.Passwd)
+	c.Metrics.authResult(methodLabel(MethodUserPasswd), err)
 	if err != nil {
-		slog.Error("用户名密码发送失败")
-		return false
-	}
-	buf = make([]byte, 2)
-	_, err = io.ReadFull(conn, buf)
-	if err != nil {
-		slog.Error("读取用户名密码认证响应失败", "err", err)
-		return false
-	}
-	if buf[0] != UserPasswdAuthVer || buf[1] != UserPasswdAuthSuccess {
 		slog.Error("用户名密码认证失败", "err", err)
 		return false
 	}
@@ -0,0 +1,78 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient_handleClientConn(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return userName == "admin" && password == "123456"
+		},
+	}}
+	c := &Client{RemoteAddr: serveForTest(t, s), Username: "admin", Passwd: "123456"}
+	address := serveForTest(t, c)
+	target := echoForTest(t)
+
+	greeting := []byte{Socks5, 2, MethodNoAuth, MethodUserPasswd}
+	request := append([]byte{Socks5, CommandConnect, RSV, AddressTypeIPv4}, target.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	payload := []byte("ping")
+	all := append(append(append([]byte{}, greeting...), request...), payload...)
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{"coalesced", [][]byte{all}},
+		{"split", [][]byte{all[:1], all[1:3], all[3:5], all[5:9], all[9:]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatalf("net.Dial error %s", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go func() {
+				for _, chunk := range tt.chunks {
+					conn.Write(chunk)
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			buff := make([]byte, 2+10+len(payload))
+			if _, err := io.ReadFull(conn, buff); err != nil {
+				t.Fatalf("io.ReadFull error %s", err)
+			}
+			if !bytes.Equal(buff[:2], []byte{Socks5, MethodNoAuth}) || buff[3] != ReplySuccess {
+				t.Fatalf("want get success reply but got %v", buff)
+			}
+			if !bytes.Equal(buff[12:], payload) {
+				t.Fatalf("want get %q but got %q", payload, buff[12:])
+			}
+		})
+	}
+
+	t.Run("test client without no auth method should be rejected", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("net.Dial error %s", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte{Socks5, 1, MethodUserPasswd})
+		buff := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != MethodNotSupported {
+			t.Fatalf("want get MethodNotSupported but got %v %s", buff, err)
+		}
+	})
+}
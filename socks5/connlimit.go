@@ -0,0 +1,204 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrTooManyConnections 并发连接数达到上限
+var ErrTooManyConnections = errors.New("socks5: too many connections")
+
+// limitKind 并发上限的种类
+type limitKind int
+
+const (
+	limitGlobal limitKind = iota
+	limitUser
+	limitIP
+)
+
+func (k limitKind) String() string {
+	switch k {
+	case limitGlobal:
+		return "global"
+	case limitUser:
+		return "user"
+	}
+	return "ip"
+}
+
+// ConnLimiter 限制并发连接数，分为全局、每个认证用户和每个客户端 IP，0 表示不限制
+// 达到全局上限时 QueueTimeout 为 0 则立即拒绝，否则排队等待其他连接结束，超时后拒绝
+// 全局上限在接受连接的循环中等待，排队期间不再接受新连接，新连接留在内核的队列中而不占用文件描述符；Serve 的 ctx 取消或 Shutdown 时停止排队
+// 用户和客户端 IP 的上限在已占用全局名额后检查，达到上限时总是立即拒绝，避免排队的连接占满全局名额
+type ConnLimiter struct {
+	// MaxConns 全局最大并发连接数
+	MaxConns int
+	// MaxConnsPerUser 每个认证用户的最大并发连接数，未认证的客户端不受限制
+	MaxConnsPerUser int
+	// MaxConnsPerIP 每个客户端 IP 的最大并发连接数
+	MaxConnsPerIP int
+	// QueueTimeout 达到全局上限时排队等待的最长时间
+	QueueTimeout time.Duration
+
+	mu sync.Mutex
+	// counts 每种上限下各 key 的连接数，全局的 key 为空
+	counts [3]map[string]int
+	// changed 有连接结束时关闭，唤醒排队的连接
+	changed chan struct{}
+	waiting int
+	queued  [3]int64
+	refused [3]int64
+}
+
+// ConnStats 并发连接的统计，用于管理接口和监控
+type ConnStats struct {
+	// Active 当前连接数
+	Active int `json:"active"`
+	// ActiveUsers 当前有连接的认证用户数
+	ActiveUsers int `json:"activeUsers"`
+	// ActiveIPs 当前有连接的客户端 IP 数
+	ActiveIPs int `json:"activeIPs"`
+	// Waiting 正在排队的连接数
+	Waiting int `json:"waiting"`
+	// Queued 按上限种类（global、user、ip）累计排队过的连接数
+	Queued map[string]int64 `json:"queued"`
+	// Refused 按上限种类累计被拒绝的连接数，包括排队超时
+	Refused map[string]int64 `json:"refused"`
+}
+
+// Stats 当前的并发连接统计
+func (l *ConnLimiter) Stats() ConnStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := ConnStats{
+		Active:      l.counts[limitGlobal][""],
+		ActiveUsers: len(l.counts[limitUser]),
+		ActiveIPs:   len(l.counts[limitIP]),
+		Waiting:     l.waiting,
+		Queued:      map[string]int64{},
+		Refused:     map[string]int64{},
+	}
+	for _, kind := range []limitKind{limitGlobal, limitUser, limitIP} {
+		stats.Queued[kind.String()] = l.queued[kind]
+		stats.Refused[kind.String()] = l.refused[kind]
+	}
+	return stats
+}
+
+func (l *ConnLimiter) limit(kind limitKind) int {
+	switch kind {
+	case limitGlobal:
+		return l.MaxConns
+	case limitUser:
+		return l.MaxConnsPerUser
+	}
+	return l.MaxConnsPerIP
+}
+
+// acquire 占用一个名额，达到上限时最多排队 queueTimeout，ctx 结束时停止排队，未设置上限时只计数；release 在连接结束时调用，可重复调用
+func (l *ConnLimiter) acquire(ctx context.Context, kind limitKind, key string, queueTimeout time.Duration) (release func(), err error) {
+	maxConns := l.limit(kind)
+	var timer *time.Timer
+	l.mu.Lock()
+	for maxConns > 0 && l.counts[kind][key] >= maxConns {
+		if queueTimeout <= 0 {
+			l.refused[kind]++
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s limit %d reached", ErrTooManyConnections, kind, maxConns)
+		}
+		if timer == nil {
+			l.queued[kind]++
+			timer = time.NewTimer(queueTimeout)
+			defer timer.Stop()
+		}
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		changed := l.changed
+		l.waiting++
+		l.mu.Unlock()
+		select {
+		case <-changed:
+			l.mu.Lock()
+			l.waiting--
+		case <-timer.C:
+			l.mu.Lock()
+			l.waiting--
+			l.refused[kind]++
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s limit %d reached, waited %s", ErrTooManyConnections, kind, maxConns, queueTimeout)
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.waiting--
+			l.refused[kind]++
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s limit %d reached, %w", ErrTooManyConnections, kind, maxConns, ctx.Err())
+		}
+	}
+	if l.counts[kind] == nil {
+		l.counts[kind] = map[string]int{}
+	}
+	l.counts[kind][key]++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.counts[kind][key]--; l.counts[kind][key] <= 0 {
+				delete(l.counts[kind], key)
+			}
+			if l.changed != nil {
+				close(l.changed)
+				l.changed = nil
+			}
+		})
+	}, nil
+}
+
+// admitGlobal 接受连接后在接受连接的循环中占用全局名额，ctx 结束时停止排队，l 为 nil 时不限制
+func (l *ConnLimiter) admitGlobal(ctx context.Context, conn net.Conn) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release, err := l.acquire(ctx, limitGlobal, "", l.QueueTimeout)
+	if err != nil {
+		slog.Warn("connection refused", "remoteAddr", conn.RemoteAddr(), "err", err)
+	}
+	return release, err
+}
+
+// admitIP 占用客户端 IP 的名额，在处理连接的协程中调用，此时已占用全局名额，达到上限时不排队
+func (l *ConnLimiter) admitIP(conn net.Conn) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	key := conn.RemoteAddr().String()
+	if ip, ok := addrIP(conn.RemoteAddr()); ok {
+		key = ip.String()
+	}
+	release, err := l.acquire(context.Background(), limitIP, key, 0)
+	if err != nil {
+		slog.Warn("connection refused", "remoteAddr", conn.RemoteAddr(), "err", err)
+	}
+	return release, err
+}
+
+// admitUser 认证后占用用户的名额，此时已占用全局和客户端 IP 的名额，达到上限时不排队；未认证的客户端不受限制
+func (l *ConnLimiter) admitUser(sess *session) (func(), error) {
+	if l == nil || sess == nil || sess.user == "" {
+		return func() {}, nil
+	}
+	release, err := l.acquire(context.Background(), limitUser, sess.user, 0)
+	if err != nil {
+		slog.Warn("connection refused", "remoteAddr", sess.clientAddr, "user", sess.user, "err", err)
+	}
+	return release, err
+}
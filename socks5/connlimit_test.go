@@ -0,0 +1,145 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter_Refuse(t *testing.T) {
+	l := &ConnLimiter{MaxConnsPerIP: 1}
+	release, err := l.acquire(context.Background(), limitIP, "198.51.100.7", 0)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if _, err := l.acquire(context.Background(), limitIP, "198.51.100.7", 0); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("want get ErrTooManyConnections but got %v", err)
+	}
+	// 其他 IP 不受影响
+	other, err := l.acquire(context.Background(), limitIP, "198.51.100.8", 0)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	other()
+	release()
+	release()
+	if _, err := l.acquire(context.Background(), limitIP, "198.51.100.7", 0); err != nil {
+		t.Fatalf("want get err == nil after release but got %s", err)
+	}
+	stats := l.Stats()
+	if stats.ActiveIPs != 1 || stats.Refused["ip"] != 1 || stats.Queued["ip"] != 0 {
+		t.Fatalf("want get 1 active ip and 1 refused but got %+v", stats)
+	}
+}
+
+func TestConnLimiter_Queue(t *testing.T) {
+	l := &ConnLimiter{MaxConns: 1, QueueTimeout: 2 * time.Second}
+	release, _ := l.acquire(context.Background(), limitGlobal, "", l.QueueTimeout)
+	time.AfterFunc(200*time.Millisecond, release)
+	start := time.Now()
+	release, err := l.acquire(context.Background(), limitGlobal, "", l.QueueTimeout)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("want get queued until released but got %s", elapsed)
+	}
+
+	// 排队超时后拒绝
+	l.QueueTimeout = 100 * time.Millisecond
+	if _, err := l.acquire(context.Background(), limitGlobal, "", l.QueueTimeout); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("want get ErrTooManyConnections but got %v", err)
+	}
+	release()
+	stats := l.Stats()
+	if stats.Active != 0 || stats.Waiting != 0 || stats.Queued["global"] != 2 || stats.Refused["global"] != 1 {
+		t.Fatalf("want get 2 queued and 1 refused but got %+v", stats)
+	}
+}
+
+func TestConnLimiter_NotQueuedWhileHoldingSlots(t *testing.T) {
+	l := &ConnLimiter{MaxConns: 3, MaxConnsPerIP: 1, MaxConnsPerUser: 1, QueueTimeout: 2 * time.Second}
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	first, _ := l.admitGlobal(context.Background(), conn)
+	defer first()
+	release, err := l.admitIP(conn)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	defer release()
+	release, err = l.admitUser(&session{user: "alice"})
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	defer release()
+	// 同一 IP 或同一用户达到上限时已占用全局名额，立即拒绝而不排队
+	releaseGlobal, _ := l.admitGlobal(context.Background(), conn)
+	start := time.Now()
+	if _, err := l.admitIP(conn); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("want get ErrTooManyConnections but got %v", err)
+	}
+	if _, err := l.admitUser(&session{user: "alice"}); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("want get ErrTooManyConnections but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("want get refused immediately but got %s", elapsed)
+	}
+	releaseGlobal()
+	stats := l.Stats()
+	if stats.Queued["ip"] != 0 || stats.Queued["user"] != 0 || stats.Refused["ip"] != 1 || stats.Refused["user"] != 1 || stats.Active != 1 {
+		t.Fatalf("want get 1 refused ip and user without queue but got %+v", stats)
+	}
+}
+
+func TestConnLimiter_QueueCanceled(t *testing.T) {
+	l := &ConnLimiter{MaxConns: 1, QueueTimeout: 10 * time.Second}
+	release, _ := l.acquire(context.Background(), limitGlobal, "", l.QueueTimeout)
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := l.acquire(ctx, limitGlobal, "", l.QueueTimeout); !errors.Is(err, ErrTooManyConnections) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("want get ErrTooManyConnections and context.Canceled but got %v", err)
+	}
+	if stats := l.Stats(); stats.Waiting != 0 {
+		t.Fatalf("want get 0 waiting but got %+v", stats)
+	}
+}
+
+func TestSocks5Server_MaxConnsPerUser(t *testing.T) {
+	target := echoForTest(t)
+	limiter := &ConnLimiter{MaxConnsPerUser: 1}
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, passwd string) bool {
+			return passwd == "123456"
+		},
+		ConnLimiter: limiter,
+	}}
+	proxyAddr := serveForTest(t, s)
+	dial := func(user string) error {
+		dialer := &Dialer{ProxyAddr: proxyAddr, Username: user, Passwd: "123456", Timeout: 5 * time.Second}
+		conn, err := dialer.Dial("tcp", target.String())
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+		return err
+	}
+	if err := dial("alice"); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	var replyError *ReplyError
+	if err := dial("alice"); !errors.As(err, &replyError) || replyError.Reply != ReplyCommonFail {
+		t.Fatalf("want get ReplyCommonFail but got %v", err)
+	}
+	if err := dial("bob"); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if stats := limiter.Stats(); stats.ActiveUsers != 2 || stats.Refused["user"] != 1 {
+		t.Fatalf("want get 2 active users and 1 refused but got %+v", stats)
+	}
+}
@@ -1,6 +1,9 @@
 package socks5
 
-import "time"
+import (
+	"net/netip"
+	"time"
+)
 
 const (
 	Socks5                byte       = 0x05
@@ -48,6 +51,21 @@ const (
 	ReplyNotDefined              ReplyType = 0x09
 )
 
+const (
+	// SOCKS4 请求
+	//VN	CD	DSTPORT	DSTIP	USERID	NULL
+	//1		1	2		4		Variable	1
+	// SOCKS4a 中 DSTIP 为 0.0.0.x（x 不为 0），USERID 之后再跟以 NULL 结尾的域名
+
+	Socks4 byte = 0x04
+	// Socks4ReplyVer SOCKS4 回复中的 VN 固定为 0
+	Socks4ReplyVer byte = 0x00
+	// Socks4ReplyGranted 请求成功
+	Socks4ReplyGranted byte = 0x5a
+	// Socks4ReplyRejected 请求被拒绝或失败
+	Socks4ReplyRejected byte = 0x5b
+)
+
 type MethodType = byte
 type AddressType = byte
 type ReplyType = byte
@@ -59,4 +77,42 @@ type Config struct {
 	Timeout       time.Duration
 	Username      string
 	Passwd        string
+	// BindTimeout BIND 等待目标主机回连的超时时间，为 0 时使用 Timeout
+	BindTimeout time.Duration
+	// EnableSocks4 同一端口上支持 SOCKS4
+	EnableSocks4 bool
+	// EnableSocks4a 同一端口上支持 SOCKS4 及 SOCKS4a 的域名扩展
+	EnableSocks4a bool
+	// DisableSocks5 关闭 SOCKS5，只在开启 SOCKS4 时有意义
+	DisableSocks5 bool
+	// DisableHTTP 关闭同一端口上的 HTTP 代理（CONNECT 隧道和普通请求转发）
+	DisableHTTP bool
+	// Authenticators 按优先级排列的认证方式，选择第一个客户端提供了的方式，为空时由 Method 和 CheckAuthFunc 生成
+	Authenticators []Authenticator
+	// CheckCertFunc 校验 TLS 客户端证书的身份（CN/SAN），为 nil 时所有通过 CA 校验的证书都允许
+	CheckCertFunc func(identity string) bool
+	// AuthThrottle 用户名密码认证的暴力破解防护，由 Method 和 CheckAuthFunc 生成的认证方式使用，Authenticators 中需自行设置
+	AuthThrottle *AuthThrottle
+	// ACL 目标地址访问控制规则，为 nil 时不限制
+	ACL *ACL
+	// AllowPrivateDestinations 允许代理访问内网、回环、链路本地、CGNAT、组播和 IPv6 ULA 地址，默认拒绝以防 SSRF
+	AllowPrivateDestinations bool
+	// AllowedClients 只接受来自这些网络的客户端连接，为空时不限制
+	AllowedClients []netip.Prefix
+	// DeniedClients 拒绝来自这些网络的客户端连接，优先于 AllowedClients
+	DeniedClients []netip.Prefix
+	// RateLimiter 转发限速，为 nil 时不限速
+	RateLimiter *RateLimiter
+	// Accounting 按用户统计流量并执行配额，为 nil 时不统计
+	Accounting *Accounting
+	// ConnLimiter 并发连接数上限，为 nil 时不限制
+	ConnLimiter *ConnLimiter
+	// Metrics 监控指标，为 nil 时不记录
+	Metrics *Metrics
+	// Sessions 正在处理的会话，用于管理接口查看和断开，为 nil 时不登记
+	Sessions *SessionRegistry
+	// AccessLog 访问日志，每个隧道结束时写一条记录，为 nil 时不记录
+	AccessLog *AccessLog
+	// AdminToken 管理接口的 Bearer Token，所有管理接口都需认证，未设置时管理接口不可用
+	AdminToken string
 }
@@ -0,0 +1,161 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ContextDialer 建立到代理服务端连接的 Dialer，net.Dialer 和 Dialer 都满足
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Dialer 通过 SOCKS5 代理服务端连接目标，DialContext 可直接用作 http.Transport.DialContext
+type Dialer struct {
+	// ProxyAddr 代理服务端地址 host:port
+	ProxyAddr string
+	// Username 不为空时使用用户名密码认证，否则使用无需认证
+	Username string
+	Passwd   string
+	// Timeout 连接代理服务端并完成握手的超时时间，为 0 时不限制
+	Timeout time.Duration
+	// ProxyDialer 连接代理服务端使用的 Dialer，为 nil 时使用 net.Dialer，可以用来串联多级代理
+	ProxyDialer ContextDialer
+}
+
+// Dial 通过代理连接目标地址，network 只支持 tcp、tcp4、tcp6
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext 通过代理连接目标地址，ctx 在握手完成前取消会中断连接
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, d.opError(network, address, fmt.Errorf("network %s not supported", network))
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	proxyDialer := d.ProxyDialer
+	if proxyDialer == nil {
+		proxyDialer = &net.Dialer{}
+	}
+	conn, err := proxyDialer.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, d.opError(network, address, err)
+	}
+
+	// 握手期间 ctx 的截止时间作用在连接上，ctx 被取消时关闭连接以中断阻塞的读写
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	errCh := make(chan error, 1)
+	var bindAddr net.Addr
+	go func() {
+		var err error
+		bindAddr, err = d.handshake(conn, address)
+		errCh <- err
+	}()
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		conn.Close()
+		<-errCh
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, d.opError(network, address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return &proxyConn{Conn: conn, remoteAddr: newProxyAddr(network, address), bindAddr: bindAddr}, nil
+}
+
+// handshake 协商认证并发送 CONNECT 请求，返回服务端回复的绑定地址
+func (d *Dialer) handshake(conn net.Conn, address string) (net.Addr, error) {
+	methods := []MethodType{MethodNoAuth}
+	if d.Username != "" {
+		methods = []MethodType{MethodUserPasswd}
+	}
+	method, err := clientNegotiate(conn, methods...)
+	if err != nil {
+		return nil, err
+	}
+	if method == MethodUserPasswd {
+		if err := clientUserPasswdAuth(conn, d.Username, d.Passwd); err != nil {
+			return nil, err
+		}
+	}
+
+	requestMessage := RequestMessage{Ver: Socks5, Command: CommandConnect, Rsv: RSV, Address: address}
+	buff, err := requestMessage.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(buff); err != nil {
+		return nil, err
+	}
+	replyMessage, err := NewReplyMessageFromServer(conn)
+	if err != nil {
+		return nil, err
+	}
+	if replyMessage.Reply != ReplySuccess {
+		return nil, &ReplyError{Reply: replyMessage.Reply}
+	}
+	bindAddress := net.JoinHostPort(replyMessage.Address, strconv.Itoa(int(replyMessage.Port)))
+	return newProxyAddr("tcp", bindAddress), nil
+}
+
+func (d *Dialer) opError(network, address string, err error) error {
+	return &net.OpError{Op: "socks5 dial", Net: network, Addr: newProxyAddr(network, address), Err: err}
+}
+
+// proxyConn 经代理建立的连接，RemoteAddr 返回目标地址而不是代理服务端地址
+type proxyConn struct {
+	net.Conn
+	remoteAddr net.Addr
+	bindAddr   net.Addr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// BindAddr 代理服务端连接目标时使用的地址（回复中的 BND.ADDR 和 BND.PORT）
+func (c *proxyConn) BindAddr() net.Addr {
+	return c.bindAddr
+}
+
+// proxyAddr 经代理连接的地址，目标为域名时无法用 net.TCPAddr 表示
+type proxyAddr struct {
+	network string
+	address string
+}
+
+// newProxyAddr IP 地址返回 *net.TCPAddr，域名返回 proxyAddr
+func newProxyAddr(network, address string) net.Addr {
+	if isIPAddress(address) {
+		if tcpAddr, err := net.ResolveTCPAddr(network, address); err == nil {
+			return tcpAddr
+		}
+	}
+	return &proxyAddr{network: network, address: address}
+}
+
+func (a *proxyAddr) Network() string { return a.network }
+func (a *proxyAddr) String() string  { return a.address }
+
+// isIPAddress 地址的 host 部分是否为 IP
+func isIPAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+var _ ContextDialer = (*Dialer)(nil)
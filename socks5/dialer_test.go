@@ -0,0 +1,92 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return userName == "admin" && password == "123456"
+		},
+	}}
+	proxyAddress := serveForTest(t, s)
+
+	t.Run("test Dialer with http.Transport should success", func(t *testing.T) {
+		httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}))
+		defer httpServer.Close()
+
+		dialer := &Dialer{ProxyAddr: proxyAddress, Username: "admin", Passwd: "123456", Timeout: time.Second}
+		client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		resp, err := client.Get(httpServer.URL)
+		if err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "hello" {
+			t.Fatalf("want get hello but got   %q", body)
+		}
+	})
+
+	t.Run("test Dialer RemoteAddr should be target", func(t *testing.T) {
+		listen, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen error %s", err)
+		}
+		defer listen.Close()
+		go func() {
+			conn, err := listen.Accept()
+			if err == nil {
+				io.Copy(conn, conn)
+				conn.Close()
+			}
+		}()
+
+		dialer := &Dialer{ProxyAddr: proxyAddress, Username: "admin", Passwd: "123456"}
+		conn, err := dialer.Dial("tcp", listen.Addr().String())
+		if err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		defer conn.Close()
+		if conn.RemoteAddr().String() != listen.Addr().String() {
+			t.Fatalf("want get %s but got   %s", listen.Addr(), conn.RemoteAddr())
+		}
+		conn.Write([]byte("ping"))
+		buff := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buff); err != nil || string(buff) != "ping" {
+			t.Fatalf("want get ping but got %q %s", buff, err)
+		}
+	})
+
+	t.Run("test Dialer with wrong passwd should fail", func(t *testing.T) {
+		dialer := &Dialer{ProxyAddr: proxyAddress, Username: "admin", Passwd: "wrong", Timeout: time.Second}
+		if _, err := dialer.Dial("tcp", "127.0.0.1:80"); err == nil {
+			t.Fatalf("want get err but got nil")
+		}
+	})
+
+	t.Run("test Dialer should return ReplyError", func(t *testing.T) {
+		listen, _ := net.Listen("tcp", "127.0.0.1:0")
+		address := listen.Addr().String()
+		listen.Close()
+
+		dialer := &Dialer{ProxyAddr: proxyAddress, Username: "admin", Passwd: "123456", Timeout: time.Second}
+		_, err := dialer.Dial("tcp", address)
+		var replyError *ReplyError
+		if !errors.As(err, &replyError) || replyError.Reply != ReplyConnectionDenied {
+			t.Fatalf("want get ReplyConnectionDenied but got %v", err)
+		}
+	})
+}
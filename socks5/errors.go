@@ -0,0 +1,84 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrAuthFailed 认证失败：用户名密码错误，或认证时提供的身份不被接受
+	ErrAuthFailed = errors.New("socks5: auth failed")
+	// ErrNoAcceptableMethod 客户端提供的认证方式服务端都不接受
+	ErrNoAcceptableMethod = errors.New("socks5: no acceptable auth method")
+	// ErrAccessDenied 目标地址被访问控制规则拒绝
+	ErrAccessDenied = errors.New("socks5: access denied by rule")
+	// ErrAddressTypeNotSupported 请求中的地址类型（ATYP）不支持
+	ErrAddressTypeNotSupported = errors.New("socks5: address type not supported")
+	// ErrCommandNotSupported 请求中的命令（CMD）不支持
+	ErrCommandNotSupported = errors.New("socks5: command not supported")
+)
+
+// dialErrorToReply 将连接目标时的错误映射为对应的回复码
+func dialErrorToReply(err error) ReplyType {
+	if err == nil {
+		return ReplySuccess
+	}
+	if errors.Is(err, ErrAccessDenied) {
+		return ReplyRegularDenied
+	}
+	if errors.Is(err, ErrCommandNotSupported) {
+		return ReplyNotSupportedCmd
+	}
+	// 域名解析失败视为主机不可达，需在超时判断之前，DNS 超时同样是主机不可达
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) {
+		return ReplyHostNotArrived
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionDenied
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.EHOSTDOWN):
+		return ReplyHostNotArrived
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.ENETDOWN):
+		return ReplyNetworkNotArrived
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		// 本机策略（如防火墙）拒绝
+		return ReplyRegularDenied
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return ReplyTSLTimeout
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return ReplyTSLTimeout
+	}
+	return ReplyCommonFail
+}
+
+// replyTexts 回复码的说明
+var replyTexts = map[ReplyType]string{
+	ReplySuccess:                 "succeeded",
+	ReplyCommonFail:              "general SOCKS server failure",
+	ReplyRegularDenied:           "connection not allowed by ruleset",
+	ReplyNetworkNotArrived:       "network unreachable",
+	ReplyHostNotArrived:          "host unreachable",
+	ReplyConnectionDenied:        "connection refused",
+	ReplyTSLTimeout:              "TTL expired",
+	ReplyNotSupportedCmd:         "command not supported",
+	ReplyNotSupportedAddressType: "address type not supported",
+}
+
+// ReplyError 服务端返回的失败回复
+type ReplyError struct {
+	Reply ReplyType
+}
+
+func (e *ReplyError) Error() string {
+	if text, ok := replyTexts[e.Reply]; ok {
+		return "socks5: " + text
+	}
+	return fmt.Sprintf("socks5: unknown reply %#x", e.Reply)
+}
@@ -0,0 +1,59 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialOpError 模拟 net.Dial 返回的错误结构
+func dialOpError(err error) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: err}}
+}
+
+func TestDialErrorToReply(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ReplyType
+	}{
+		{"nil", nil, ReplySuccess},
+		{"connection refused", dialOpError(syscall.ECONNREFUSED), ReplyConnectionDenied},
+		{"host unreachable", dialOpError(syscall.EHOSTUNREACH), ReplyHostNotArrived},
+		{"host down", dialOpError(syscall.EHOSTDOWN), ReplyHostNotArrived},
+		{"network unreachable", dialOpError(syscall.ENETUNREACH), ReplyNetworkNotArrived},
+		{"permission denied", dialOpError(syscall.EPERM), ReplyRegularDenied},
+		{"access denied", dialOpError(syscall.EACCES), ReplyRegularDenied},
+		{"dns not found", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nx.invalid", IsNotFound: true}}, ReplyHostNotArrived},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "slow.invalid", IsTimeout: true}, ReplyHostNotArrived},
+		{"dial timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, ReplyTSLTimeout},
+		{"context deadline", fmt.Errorf("dial: %w", context.DeadlineExceeded), ReplyTSLTimeout},
+		{"denied by acl", &net.OpError{Op: "dial", Err: fmt.Errorf("%w: rule", ErrAccessDenied)}, ReplyRegularDenied},
+		{"other", errors.New("unknown"), ReplyCommonFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dialErrorToReply(tt.err); got != tt.want {
+				t.Fatalf("want get %#x but got   %#x", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("real connection refused", func(t *testing.T) {
+		listen, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen error %s", err)
+		}
+		address := listen.Addr().String()
+		listen.Close()
+		_, err = net.DialTimeout("tcp", address, time.Second)
+		if got := dialErrorToReply(err); got != ReplyConnectionDenied {
+			t.Fatalf("want get %#x but got   %#x (%v)", ReplyConnectionDenied, got, err)
+		}
+	})
+}
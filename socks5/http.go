@@ -0,0 +1,267 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// httpMethods 用于识别 HTTP 代理请求的方法前缀
+var httpMethods = []string{
+	http.MethodConnect + " ",
+	http.MethodGet + " ",
+	http.MethodHead + " ",
+	http.MethodPost + " ",
+	http.MethodPut + " ",
+	http.MethodPatch + " ",
+	http.MethodDelete + " ",
+	http.MethodOptions + " ",
+	http.MethodTrace + " ",
+}
+
+// hopHeaders 逐跳首部，代理转发时需要去掉
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// isHTTPRequest 预读连接开头的字节，判断是否为 HTTP 请求
+func isHTTPRequest(reader *bufio.Reader) bool {
+	first, err := reader.Peek(1)
+	if err != nil || first[0] < 'A' || first[0] > 'Z' {
+		return false
+	}
+	// 最长的方法 "OPTIONS " 为 8 个字节，请求行一定比它长
+	buff, err := reader.Peek(len(http.MethodOptions) + 1)
+	if err != nil {
+		return false
+	}
+	for _, method := range httpMethods {
+		if strings.HasPrefix(string(buff), method) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHTTP 处理 HTTP 代理：CONNECT 建立隧道，其余绝对路径的请求直接转发
+func (s5 *Socks5Server) handleHTTP(conn net.Conn, reader *bufio.Reader, config *Config, certIdentity string) error {
+	// 每个请求都需认证，同一连接上的请求使用各自认证得到的身份
+	sess := newSession(conn, certIdentity, "http")
+	defer config.Sessions.add(sess)()
+	// 同一连接上的请求可能认证为不同的用户，不复用到目标的连接，每个请求都在连接时按各自的用户检查访问控制和配额
+	transport := &http.Transport{
+		Proxy:             nil,
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return s5.dialTarget(sess, address)
+		},
+		DisableCompression: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if certIdentity != "" {
+			config.Metrics.authResult("tlscert", nil)
+		} else {
+			user, ok := httpAuth(req, config, conn.RemoteAddr())
+			if !ok {
+				config.Metrics.handshake("http", ErrAuthFailed)
+				slog.Error("http proxy auth failed", "remoteAddr", conn.RemoteAddr())
+				resp := newHTTPResponse(req, http.StatusProxyAuthRequired)
+				resp.Header.Set("Proxy-Authenticate", `Basic realm="socks5"`)
+				resp.Write(conn)
+				return fmt.Errorf("http proxy: %w", ErrAuthFailed)
+			}
+			sess.setUser(user)
+		}
+		config.Metrics.handshake("http", nil)
+		release, err := s5.Config.ConnLimiter.admitUser(sess)
+		if err != nil {
+			newHTTPResponse(req, http.StatusTooManyRequests).Write(conn)
+			return err
+		}
+		if req.Method == http.MethodConnect {
+			defer release()
+			return s5.handleHTTPConnect(conn, sess, req)
+		}
+		keepAlive, err := s5.handleHTTPForward(conn, sess, req, transport)
+		release()
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// handleHTTPConnect 连接目标并建立隧道
+func (s5 *Socks5Server) handleHTTPConnect(conn net.Conn, sess *session, req *http.Request) (err error) {
+	address := req.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	sess.beginTunnel(commandName(CommandConnect), address)
+	defer func() { s5.logTunnel(sess, err) }()
+	targetConn, err := s5.dialTarget(sess, address)
+	if err != nil {
+		newHTTPResponse(req, replyToHTTPStatus(dialErrorToReply(err))).Write(conn)
+		return err
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		targetConn.Close()
+		return err
+	}
+	return s5.forward(sess, conn, targetConn)
+}
+
+// handleHTTPForward 转发普通的 HTTP 请求，返回连接是否可以继续复用
+func (s5 *Socks5Server) handleHTTPForward(conn net.Conn, sess *session, req *http.Request, transport http.RoundTripper) (_ bool, err error) {
+	sess.beginTunnel("http", req.URL.Host)
+	defer func() { s5.logTunnel(sess, err) }()
+	if !req.URL.IsAbs() || req.URL.Host == "" {
+		newHTTPResponse(req, http.StatusBadRequest).Write(conn)
+		return false, fmt.Errorf("http proxy request uri %q not absolute", req.RequestURI)
+	}
+	keepAlive := !req.Close
+	if err := s5.checkQuota(sess); err != nil {
+		newHTTPResponse(req, replyToHTTPStatus(dialErrorToReply(err))).Write(conn)
+		return false, err
+	}
+	req.RequestURI = ""
+	removeHopHeaders(req.Header)
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	req = req.WithContext(ctx)
+	// 与 forward 一样限速和统计流量，请求体为上传，响应为下载
+	var uploadLimiters, downloadLimiters []*rate.Limiter
+	if limiter := s5.Config.RateLimiter; limiter != nil {
+		var release func()
+		uploadLimiters, downloadLimiters, release = limiter.acquire(sess.user)
+		defer release()
+	}
+	var upload io.Reader = &rateLimitedReader{ctx: ctx, reader: req.Body, limiters: uploadLimiters}
+	var download io.Writer = conn
+	if accounting := s5.Config.Accounting; accounting != nil && sess.user != "" {
+		user := sess.user
+		defer accounting.track(user, cancel)()
+		upload = &countingReader{ctx: ctx, reader: upload, count: func(n int64) { accounting.count(user, Usage{Upload: n}) }}
+		download = &countingWriter{writer: download, count: func(n int64) { accounting.count(user, Usage{Download: n}) }}
+	}
+	upload = &countingReader{ctx: ctx, reader: upload, count: sess.countBytes("upload")}
+	download = &countingWriter{writer: download, count: sess.countBytes("download")}
+	// 没有请求体时必须保持 http.NoBody，否则会按长度未知的请求体发送
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{upload, req.Body}
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		slog.Error("http proxy round trip error", "url", req.URL, "err", err)
+		newHTTPResponse(req, replyToHTTPStatus(dialErrorToReply(err))).Write(conn)
+		return false, err
+	}
+	defer resp.Body.Close()
+	sess.setEstablished()
+	removeHopHeaders(resp.Header)
+	// 到目标的连接不复用，是否保持与客户端的连接只取决于客户端的请求
+	resp.Close = !keepAlive
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{&rateLimitedReader{ctx: ctx, reader: resp.Body, limiters: downloadLimiters}, resp.Body}
+	if err := resp.Write(download); err != nil {
+		// ctx 被取消说明超出配额被断开
+		if ctx.Err() != nil {
+			sess.setReason("quota exceeded")
+		}
+		return false, err
+	}
+	return keepAlive, nil
+}
+
+// httpAuth 校验 Proxy-Authorization，与 SOCKS5 使用同一组认证方式，按优先级取第一个对该客户端开放的方式，返回认证得到的用户名
+func httpAuth(req *http.Request, config *Config, addr net.Addr) (string, bool) {
+	for _, authenticator := range config.authenticators() {
+		if !sourceAllowed(authenticator, addr) {
+			continue
+		}
+		method := methodLabel(authenticator.Method())
+		if authenticator.Method() == MethodNoAuth {
+			config.Metrics.authResult(method, nil)
+			return "", true
+		}
+		if checker, ok := authenticator.(PasswdChecker); ok {
+			// 借用 Request.BasicAuth 解析 Proxy-Authorization
+			authReq := http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+			userName, passwd, ok := authReq.BasicAuth()
+			if !ok {
+				config.Metrics.authResult(method, ErrAuthFailed)
+				return "", false
+			}
+			err := checker.CheckPasswd(addr, userName, passwd)
+			config.Metrics.authResult(method, err)
+			return userName, err == nil
+		}
+	}
+	config.Metrics.authResult("none", ErrNoAcceptableMethod)
+	return "", false
+}
+
+// replyToHTTPStatus 将回复码转换为 HTTP 状态码
+func replyToHTTPStatus(replyType ReplyType) int {
+	switch replyType {
+	case ReplyRegularDenied:
+		return http.StatusForbidden
+	case ReplyTSLTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func newHTTPResponse(req *http.Request, statusCode int) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     http.Header{},
+		Close:      true,
+	}
+}
+
+func removeHopHeaders(header http.Header) {
+	// Connection 中列出的首部同样是逐跳首部
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
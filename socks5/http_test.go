@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSocks5Server_HTTP(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return userName == "admin" && password == "123456"
+		},
+	}}
+	proxyAddress := serveForTest(t, s)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer httpServer.Close()
+
+	t.Run("test http forward should success", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "123456"), Host: proxyAddress}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(httpServer.URL + "/path")
+			if err != nil {
+				t.Fatalf("want get err == nil but got err  %s", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "hello /path" {
+				t.Fatalf("want get hello /path but got   %q", body)
+			}
+		}
+	})
+
+	t.Run("test http forward with wrong passwd should get 407", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "wrong"), Host: proxyAddress}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		resp, err := client.Get(httpServer.URL)
+		if err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			t.Fatalf("want get 407 but got   %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("test http connect should tunnel", func(t *testing.T) {
+		target := echoForTest(t)
+		conn, err := net.Dial("tcp", proxyAddress)
+		if err != nil {
+			t.Fatalf("net.Dial error %s", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// 隧道数据紧跟在 CONNECT 请求之后发出
+		io.WriteString(conn, "CONNECT "+target.String()+" HTTP/1.1\r\nHost: "+target.String()+
+			"\r\nProxy-Authorization: Basic YWRtaW46MTIzNDU2\r\n\r\nping")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("want get 200 but got %v %v", resp, err)
+		}
+		buff := make([]byte, 4)
+		if _, err := io.ReadFull(reader, buff); err != nil || string(buff) != "ping" {
+			t.Fatalf("want get ping but got %q %s", buff, err)
+		}
+	})
+}
+
+func TestSocks5Server_HTTPPerRequestACL(t *testing.T) {
+	acl := &ACL{Rules: []ACLRule{{Action: "deny", Users: []string{"bob"}}}}
+	if err := acl.Compile(); err != nil {
+		t.Fatalf("acl.Compile error %s", err)
+	}
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return password == "123456"
+		},
+		ACL: acl,
+	}}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer httpServer.Close()
+	conn, err := net.Dial("tcp", serveForTest(t, s))
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// alice 和 bob 在同一连接上先后请求同一目标，bob 不能复用 alice 建立的连接
+	for _, tt := range []struct {
+		auth string
+		want int
+	}{{"YWxpY2U6MTIzNDU2", http.StatusOK}, {"Ym9iOjEyMzQ1Ng==", http.StatusForbidden}} {
+		io.WriteString(conn, "GET "+httpServer.URL+"/ HTTP/1.1\r\nHost: "+httpServer.Listener.Addr().String()+
+			"\r\nProxy-Authorization: Basic "+tt.auth+"\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("http.ReadResponse error %s", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Fatalf("want get %d but got %d", tt.want, resp.StatusCode)
+		}
+	}
+}
@@ -40,7 +40,7 @@ type RequestMessage struct {
 	Rsv         byte
 	AddressType byte
 	Address     string
-	Port        int16
+	Port        uint16
 }
 
 // ReplyMessage
@@ -52,5 +52,31 @@ type ReplyMessage struct {
 	Rsv         byte
 	AddressType byte
 	Address     string
-	Port        int16
+	Port        uint16
+}
+
+// UdpMessage UDP ASSOCIATE 中转的数据报
+//RSV		FRAG	ATYP	DST.ADDR	DST.PORT	DATA
+//X’0000’	1		1		Variable	2			Variable
+type UdpMessage struct {
+	Rsv         uint16
+	Frag        byte
+	AddressType byte
+	Address     string
+	Data        []byte
+}
+
+// Socks4RequestMessage
+//VN	CD	DSTPORT	DSTIP	USERID		NULL	[DOMAIN	NULL]
+//1		1	2		4		Variable	1		[Variable	1]
+type Socks4RequestMessage struct {
+	Ver     byte
+	Command CommandType
+	Port    uint16
+	IP      [4]byte
+	UserId  string
+	// Domain SOCKS4a 的目标域名，SOCKS4 时为空
+	Domain string
+	// Address host:port 形式的目标地址
+	Address string
 }
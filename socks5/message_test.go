@@ -0,0 +1,67 @@
+package socks5
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// domainAddr 以域名形式表示的地址
+type domainAddr string
+
+func (a domainAddr) Network() string { return "tcp" }
+func (a domainAddr) String() string  { return string(a) }
+
+func TestNewReplyMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindAddr net.Addr
+		want     []byte
+	}{
+		{"nil", nil, []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0}},
+		{"ipv4", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 0x1139}, []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4, 10, 0, 0, 1, 0x11, 0x39}},
+		{"ipv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 65535}, []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0xff}},
+		{"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}, []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4, 127, 0, 0, 1, 0, 53}},
+		{"domain", domainAddr("proxy.local:1080"), append(append([]byte{Socks5, ReplySuccess, RSV, AddressTypeDomain, 11}, "proxy.local"...), 0x04, 0x38)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buff bytes.Buffer
+			if _, err := NewReplyMessage(ReplySuccess, tt.bindAddr).WriteTo(&buff); err != nil {
+				t.Fatalf("want get err == nil but got err  %s", err)
+			}
+			if !bytes.Equal(buff.Bytes(), tt.want) {
+				t.Fatalf("want get %v but got   %v", tt.want, buff.Bytes())
+			}
+		})
+	}
+}
+
+func TestNewRequestReplySuccessMessage(t *testing.T) {
+	var buff bytes.Buffer
+	if err := NewRequestReplySuccessMessage(&buff); err != nil {
+		t.Fatalf("want get err == nil but got err  %s", err)
+	}
+	if want := []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0}; !bytes.Equal(buff.Bytes(), want) {
+		t.Fatalf("want get %v but got   %v", want, buff.Bytes())
+	}
+}
+
+func TestMessage_HighPort(t *testing.T) {
+	// 超过 32767 的端口
+	reply := NewReplyMessage(ReplySuccess, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 65535})
+	if reply.Port != 65535 {
+		t.Fatalf("want get 65535 but got %d", reply.Port)
+	}
+	var buff bytes.Buffer
+	reply.WriteTo(&buff)
+	reply, err := NewReplyMessageFromServer(&buff)
+	if err != nil || reply.Port != 65535 {
+		t.Fatalf("want get 65535 but got %v %v", reply, err)
+	}
+
+	request, err := NewRequestMessageFromClient(bytes.NewReader([]byte{Socks5, CommandConnect, RSV, AddressTypeIPv4, 10, 0, 0, 1, 0xc3, 0x50}))
+	if err != nil || request.Port != 50000 || request.Address != "10.0.0.1:50000" {
+		t.Fatalf("want get 10.0.0.1:50000 but got %v %v", request, err)
+	}
+}
@@ -0,0 +1,261 @@
+package socks5
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics 服务端和本地客户端的监控指标，以 Prometheus 文本格式输出
+// 各方法的接收者为 nil 时不记录，未开启监控时无需判断
+type Metrics struct {
+	connections  *counterVec
+	rejected     *counterVec
+	handshakes   *counterVec
+	auths        *counterVec
+	connects     *counterVec
+	dialDuration *histogram
+	bytes        *counterVec
+	sessions     *gauge
+}
+
+// dialBuckets 连接目标耗时的分桶，单位秒
+var dialBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		connections:  newCounterVec("socks5_connections_total", "Accepted client connections."),
+		rejected:     newCounterVec("socks5_connections_rejected_total", "Client connections closed before handshake, by reason.", "reason"),
+		handshakes:   newCounterVec("socks5_handshakes_total", "Handshakes (tls, protocol negotiation and auth) by protocol and result.", "protocol", "result"),
+		auths:        newCounterVec("socks5_auth_total", "Authentication attempts by method and result.", "method", "result"),
+		connects:     newCounterVec("socks5_connect_total", "CONNECT results by reply code.", "reply"),
+		dialDuration: newHistogram("socks5_dial_duration_seconds", "Time to dial the target (server) or the remote server (client).", dialBuckets),
+		bytes:        newCounterVec("socks5_relayed_bytes_total", "Bytes relayed by direction, upload is client to target.", "direction"),
+		sessions:     &gauge{name: "socks5_active_sessions", help: "Client connections being handled."},
+	}
+}
+
+// ServeHTTP 输出 Prometheus 文本格式的指标
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writer := bufio.NewWriter(w)
+	m.connections.write(writer)
+	m.rejected.write(writer)
+	m.handshakes.write(writer)
+	m.auths.write(writer)
+	m.connects.write(writer)
+	m.dialDuration.write(writer)
+	m.bytes.write(writer)
+	m.sessions.write(writer)
+	if err := writer.Flush(); err != nil {
+		slog.Error("metrics write response failed", "err", err)
+	}
+}
+
+func (m *Metrics) acceptedConn() {
+	if m != nil {
+		m.connections.add(1)
+	}
+}
+
+// rejectedConn reason 如 client_filter、conn_limit
+func (m *Metrics) rejectedConn(reason string) {
+	if m != nil {
+		m.rejected.add(1, reason)
+	}
+}
+
+// handshake protocol 为 tls、socks5、socks4 或 http
+func (m *Metrics) handshake(protocol string, err error) {
+	if m != nil {
+		m.handshakes.add(1, protocol, resultLabel(err))
+	}
+}
+
+// authResult method 为 noauth、userpasswd、tlscert，没有可接受的认证方式时为 none
+func (m *Metrics) authResult(method string, err error) {
+	if m != nil {
+		m.auths.add(1, method, resultLabel(err))
+	}
+}
+
+// connectResult 记录 CONNECT 的回复码，包括连接目标之前被拒绝的请求
+func (m *Metrics) connectResult(reply ReplyType) {
+	if m != nil {
+		m.connects.add(1, fmt.Sprintf("0x%02x", reply))
+	}
+}
+
+// dialed 记录连接耗时，包括连接失败
+func (m *Metrics) dialed(duration time.Duration) {
+	if m != nil {
+		m.dialDuration.observe(duration.Seconds())
+	}
+}
+
+// countBytes 返回统计转发字节数的函数，direction 为 upload 或 download，m 不能为 nil
+func (m *Metrics) countBytes(direction string) func(n int64) {
+	return func(n int64) {
+		m.bytes.add(float64(n), direction)
+	}
+}
+
+// sessionStarted 活跃连接数加一，返回的函数在连接结束时调用
+func (m *Metrics) sessionStarted() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.sessions.add(1)
+	return func() { m.sessions.add(-1) }
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// methodLabel 认证方式的指标标签
+func methodLabel(method MethodType) string {
+	switch method {
+	case MethodNoAuth:
+		return "noauth"
+	case MethodUserPasswd:
+		return "userpasswd"
+	case MethodGssApi:
+		return "gssapi"
+	}
+	return fmt.Sprintf("0x%02x", method)
+}
+
+// counterVec 带标签的计数器，标签值按顺序以 \xff 连接作为 key
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(key, "\xff")), formatValue(c.values[key]))
+	}
+}
+
+// gauge 不带标签的仪表盘
+type gauge struct {
+	name  string
+	help  string
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *gauge) add(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += v
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value))
+}
+
+// histogram 不带标签的直方图，counts 为各桶（不含 +Inf）的非累计计数
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, bucket := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bucket), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels 标签值中的反斜杠、双引号和换行需转义
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		fmt.Fprintf(&b, "%s=\"%s\"", name, value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
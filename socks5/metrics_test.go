@@ -0,0 +1,132 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricsForTest 返回 Prometheus 文本格式的输出
+func metricsForTest(m *Metrics) string {
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
+
+// assertMetricsForTest 服务端可能在客户端收到回复之后才记录，最多等待 1 秒
+func assertMetricsForTest(t *testing.T, m *Metrics, lines ...string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for _, line := range lines {
+		output := metricsForTest(m)
+		for !strings.Contains(output, line+"\n") {
+			if time.Now().After(deadline) {
+				t.Fatalf("want get %q but got\n%s", line, output)
+			}
+			time.Sleep(10 * time.Millisecond)
+			output = metricsForTest(m)
+		}
+	}
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := NewMetrics()
+	m.acceptedConn()
+	m.rejectedConn(`a"b\c`)
+	m.authResult("userpasswd", ErrAuthFailed)
+	m.authResult("userpasswd", nil)
+	m.authResult("userpasswd", nil)
+	m.dialed(30 * time.Millisecond)
+	m.dialed(3 * time.Second)
+	done := m.sessionStarted()
+	m.sessionStarted()
+	done()
+	var nilMetrics *Metrics
+	nilMetrics.acceptedConn()
+
+	assertMetricsForTest(t, m,
+		"# TYPE socks5_connections_total counter",
+		"socks5_connections_total 1",
+		`socks5_connections_rejected_total{reason="a\"b\\c"} 1`,
+		`socks5_auth_total{method="userpasswd",result="failure"} 1`,
+		`socks5_auth_total{method="userpasswd",result="success"} 2`,
+		"# TYPE socks5_dial_duration_seconds histogram",
+		`socks5_dial_duration_seconds_bucket{le="0.025"} 0`,
+		`socks5_dial_duration_seconds_bucket{le="0.05"} 1`,
+		`socks5_dial_duration_seconds_bucket{le="5"} 2`,
+		`socks5_dial_duration_seconds_bucket{le="+Inf"} 2`,
+		"socks5_dial_duration_seconds_sum 3.03",
+		"socks5_dial_duration_seconds_count 2",
+		"socks5_active_sessions 1",
+	)
+}
+
+func TestMetrics_ServerAndClient(t *testing.T) {
+	serverMetrics, clientMetrics := NewMetrics(), NewMetrics()
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, password string) bool {
+			return userName == "admin" && password == "123456"
+		},
+		Metrics: serverMetrics,
+	}}
+	proxyAddr := serveForTest(t, s)
+	c := &Client{RemoteAddr: proxyAddr, Username: "admin", Passwd: "123456", Metrics: clientMetrics}
+	address := serveForTest(t, c)
+	target := echoForTest(t)
+
+	// 通过本地客户端转发 ping
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := append([]byte{Socks5, 1, MethodNoAuth, Socks5, CommandConnect, RSV, AddressTypeIPv4}, target.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	conn.Write(append(request, "ping"...))
+	buff := make([]byte, 2+10+4)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[3] != ReplySuccess || string(buff[12:]) != "ping" {
+		t.Fatalf("want get success reply and ping but got %v %v", buff, err)
+	}
+
+	// 连接被拒绝和密码错误
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	dialer := &Dialer{ProxyAddr: proxyAddr, Username: "admin", Passwd: "123456", Timeout: time.Second}
+	var replyError *ReplyError
+	if _, err := dialer.Dial("tcp", closed.Addr().String()); !errors.As(err, &replyError) {
+		t.Fatalf("want get ReplyError but got %v", err)
+	}
+	dialer.Passwd = "wrong"
+	if _, err := dialer.Dial("tcp", target.String()); err == nil {
+		t.Fatalf("want get err but got nil")
+	}
+
+	assertMetricsForTest(t, serverMetrics,
+		`socks5_handshakes_total{protocol="socks5",result="failure"} 1`,
+		`socks5_handshakes_total{protocol="socks5",result="success"} 2`,
+		`socks5_auth_total{method="userpasswd",result="failure"} 1`,
+		`socks5_auth_total{method="userpasswd",result="success"} 2`,
+		`socks5_connect_total{reply="0x00"} 1`,
+		`socks5_connect_total{reply="0x05"} 1`,
+		"socks5_dial_duration_seconds_count 2",
+		`socks5_relayed_bytes_total{direction="download"} 4`,
+		`socks5_relayed_bytes_total{direction="upload"} 4`,
+	)
+	assertMetricsForTest(t, clientMetrics,
+		`socks5_handshakes_total{protocol="socks5",result="success"} 1`,
+		`socks5_auth_total{method="userpasswd",result="success"} 1`,
+		`socks5_connect_total{reply="0x00"} 1`,
+		"socks5_dial_duration_seconds_count 1",
+		`socks5_relayed_bytes_total{direction="download"} 4`,
+		`socks5_relayed_bytes_total{direction="upload"} 4`,
+	)
+}
@@ -0,0 +1,241 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Bandwidth 上传（客户端到目标）和下载（目标到客户端）的限速，单位字节/秒，0 表示不限速
+type Bandwidth struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// RateLimiter 令牌桶限速，分为全局（所有连接共享）、每个用户（该用户的所有连接共享）和每个连接三级，转发时同时受三者限制
+// 各级限速可在运行时调整，对已建立的连接立即生效
+type RateLimiter struct {
+	mu sync.Mutex
+	// global 全局限速
+	global bandwidthLimiter
+	// userBandwidth 用户限速，未单独设置的用户使用 defaultUser
+	userBandwidth map[string]Bandwidth
+	defaultUser   Bandwidth
+	// users 有连接的用户的限速器
+	users map[string]*userLimiter
+	// perConn 每个连接的限速及已建立连接的限速器
+	perConn Bandwidth
+	conns   map[*bandwidthLimiter]struct{}
+}
+
+// bandwidthLimiter 一对上传下载令牌桶
+type bandwidthLimiter struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+type userLimiter struct {
+	bandwidthLimiter
+	refs int
+}
+
+// NewRateLimiter global 全局限速，perUser 每个用户的默认限速，perConn 每个连接的限速
+func NewRateLimiter(global, perUser, perConn Bandwidth) *RateLimiter {
+	return &RateLimiter{
+		global:        newBandwidthLimiter(global),
+		userBandwidth: map[string]Bandwidth{},
+		defaultUser:   perUser,
+		users:         map[string]*userLimiter{},
+		perConn:       perConn,
+		conns:         map[*bandwidthLimiter]struct{}{},
+	}
+}
+
+// RateLimits 各级限速的设置，用于管理接口查看和调整
+type RateLimits struct {
+	Global        Bandwidth            `json:"global"`
+	PerUser       Bandwidth            `json:"perUser"`
+	PerConnection Bandwidth            `json:"perConnection"`
+	Users         map[string]Bandwidth `json:"users"`
+}
+
+// Limits 当前的限速设置
+func (l *RateLimiter) Limits() RateLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := RateLimits{
+		Global:        Bandwidth{Upload: limitBytes(l.global.upload), Download: limitBytes(l.global.download)},
+		PerUser:       l.defaultUser,
+		PerConnection: l.perConn,
+		Users:         map[string]Bandwidth{},
+	}
+	for user, bandwidth := range l.userBandwidth {
+		limits.Users[user] = bandwidth
+	}
+	return limits
+}
+
+// SetLimits 整体替换限速设置，Users 中没有的用户恢复使用 PerUser
+func (l *RateLimiter) SetLimits(limits RateLimits) {
+	l.SetGlobal(limits.Global)
+	l.SetDefaultUser(limits.PerUser)
+	l.SetPerConnection(limits.PerConnection)
+	for user := range l.Limits().Users {
+		if _, ok := limits.Users[user]; !ok {
+			l.ResetUser(user)
+		}
+	}
+	for user, bandwidth := range limits.Users {
+		l.SetUser(user, bandwidth)
+	}
+}
+
+func limitBytes(limiter *rate.Limiter) int64 {
+	if limiter.Limit() == rate.Inf {
+		return 0
+	}
+	return int64(limiter.Limit())
+}
+
+// SetGlobal 调整全局限速
+func (l *RateLimiter) SetGlobal(bandwidth Bandwidth) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global.set(bandwidth)
+}
+
+// SetDefaultUser 调整未单独设置限速的用户的限速
+func (l *RateLimiter) SetDefaultUser(bandwidth Bandwidth) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultUser = bandwidth
+	for user, limiter := range l.users {
+		if _, ok := l.userBandwidth[user]; !ok {
+			limiter.set(bandwidth)
+		}
+	}
+}
+
+// SetUser 单独设置用户的限速
+func (l *RateLimiter) SetUser(user string, bandwidth Bandwidth) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.userBandwidth[user] = bandwidth
+	if limiter, ok := l.users[user]; ok {
+		limiter.set(bandwidth)
+	}
+}
+
+// ResetUser 取消用户的单独设置，恢复使用默认的用户限速
+func (l *RateLimiter) ResetUser(user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.userBandwidth, user)
+	if limiter, ok := l.users[user]; ok {
+		limiter.set(l.defaultUser)
+	}
+}
+
+// SetPerConnection 调整每个连接的限速，包括已建立的连接
+func (l *RateLimiter) SetPerConnection(bandwidth Bandwidth) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perConn = bandwidth
+	for limiter := range l.conns {
+		limiter.set(bandwidth)
+	}
+}
+
+// acquire 为一个连接取得适用的限速器，user 为空（未认证）时不受用户限速；release 在连接结束时调用
+func (l *RateLimiter) acquire(user string) (upload, download []*rate.Limiter, release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	conn := newBandwidthLimiter(l.perConn)
+	l.conns[&conn] = struct{}{}
+	upload = []*rate.Limiter{conn.upload, l.global.upload}
+	download = []*rate.Limiter{conn.download, l.global.download}
+
+	var userLimit *userLimiter
+	if user != "" {
+		userLimit = l.users[user]
+		if userLimit == nil {
+			bandwidth, ok := l.userBandwidth[user]
+			if !ok {
+				bandwidth = l.defaultUser
+			}
+			userLimit = &userLimiter{bandwidthLimiter: newBandwidthLimiter(bandwidth)}
+			l.users[user] = userLimit
+		}
+		userLimit.refs++
+		upload = append(upload, userLimit.upload)
+		download = append(download, userLimit.download)
+	}
+	release = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.conns, &conn)
+		if userLimit != nil {
+			if userLimit.refs--; userLimit.refs == 0 {
+				delete(l.users, user)
+			}
+		}
+	}
+	return upload, download, release
+}
+
+func newBandwidthLimiter(bandwidth Bandwidth) bandwidthLimiter {
+	limiter := bandwidthLimiter{upload: rate.NewLimiter(rate.Inf, 0), download: rate.NewLimiter(rate.Inf, 0)}
+	limiter.set(bandwidth)
+	return limiter
+}
+
+func (b *bandwidthLimiter) set(bandwidth Bandwidth) {
+	setLimit(b.upload, bandwidth.Upload)
+	setLimit(b.download, bandwidth.Download)
+}
+
+// setLimit 令牌桶容量为 0.1 秒的流量，避免空闲后瞬间突发过多
+func setLimit(limiter *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetBurst(int(max(bytesPerSecond/10, 1024)))
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// rateLimitedReader 每次读取后按读到的字节数从所有令牌桶中取令牌，取不到时等待
+type rateLimitedReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, limiter := range r.limiters {
+			if waitErr := waitN(r.ctx, limiter, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// waitN 超过令牌桶容量时分多次等待
+func waitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		if limiter.Limit() == rate.Inf {
+			return nil
+		}
+		size := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, size); err != nil {
+			return err
+		}
+		n -= size
+	}
+	return nil
+}
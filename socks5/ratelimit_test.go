@@ -0,0 +1,176 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// forwardForTest 用内存管道运行 forward，返回客户端一端和目标一端
+func forwardForTest(t *testing.T, s *Socks5Server, user string) (client net.Conn, target net.Conn) {
+	t.Helper()
+	client, serverSide := net.Pipe()
+	targetSide, target := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.forward(&session{user: user}, serverSide, targetSide)
+		serverSide.Close()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		target.Close()
+		<-done
+	})
+	return client, target
+}
+
+// transferForTest 从 writer 写入 size 字节并在 reader 读完，返回耗时
+func transferForTest(t *testing.T, writer io.Writer, reader io.Reader, size int) time.Duration {
+	t.Helper()
+	start := time.Now()
+	go writer.Write(make([]byte, size))
+	if _, err := io.ReadFull(reader, make([]byte, size)); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	return time.Since(start)
+}
+
+// assertDurationForTest 令牌桶初始有 0.1 秒的容量，允许一定误差
+func assertDurationForTest(t *testing.T, got, want time.Duration) {
+	t.Helper()
+	if got < want*7/10 || got > want*15/10 {
+		t.Fatalf("want get about %s but got %s", want, got)
+	}
+}
+
+func TestRateLimiter_PerConnection(t *testing.T) {
+	s := &Socks5Server{Config: Config{RateLimiter: NewRateLimiter(Bandwidth{}, Bandwidth{}, Bandwidth{Upload: 200 << 10, Download: 400 << 10})}}
+	client, target := forwardForTest(t, s, "")
+	// 下载 200KB，400KB/s，约 0.5 秒
+	assertDurationForTest(t, transferForTest(t, target, client, 200<<10), 500*time.Millisecond)
+	// 上传 100KB，200KB/s，约 0.5 秒
+	assertDurationForTest(t, transferForTest(t, client, target, 100<<10), 500*time.Millisecond)
+}
+
+func TestRateLimiter_HTTPForward(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Timeout:                  time.Second,
+		RateLimiter:              NewRateLimiter(Bandwidth{}, Bandwidth{}, Bandwidth{Upload: 200 << 10, Download: 400 << 10}),
+	}}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			io.Copy(io.Discard, r.Body)
+			return
+		}
+		w.Write(make([]byte, 200<<10))
+	}))
+	defer httpServer.Close()
+	proxyURL := &url.URL{Scheme: "http", Host: serveForTest(t, s)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// 下载 200KB，400KB/s，约 0.5 秒
+	start := time.Now()
+	resp, err := client.Get(httpServer.URL)
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assertDurationForTest(t, time.Since(start), 500*time.Millisecond)
+
+	// 上传 100KB，200KB/s，约 0.5 秒
+	start = time.Now()
+	resp, err = client.Post(httpServer.URL, "application/octet-stream", strings.NewReader(strings.Repeat("a", 100<<10)))
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	resp.Body.Close()
+	assertDurationForTest(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRateLimiter_SharedByUser(t *testing.T) {
+	s := &Socks5Server{Config: Config{RateLimiter: NewRateLimiter(Bandwidth{}, Bandwidth{Download: 400 << 10}, Bandwidth{})}}
+	// 同一用户的两个连接共享 400KB/s，共 200KB 约 0.5 秒
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		client, target := forwardForTest(t, s, "alice")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transferForTest(t, target, client, 100<<10)
+		}()
+	}
+	wg.Wait()
+	assertDurationForTest(t, time.Since(start), 500*time.Millisecond)
+
+	// 单独设置的用户限速
+	s.Config.RateLimiter.SetUser("bob", Bandwidth{Download: 200 << 10})
+	client, target := forwardForTest(t, s, "bob")
+	assertDurationForTest(t, transferForTest(t, target, client, 100<<10), 500*time.Millisecond)
+}
+
+func TestRateLimiter_Global(t *testing.T) {
+	s := &Socks5Server{Config: Config{RateLimiter: NewRateLimiter(Bandwidth{Download: 400 << 10}, Bandwidth{}, Bandwidth{})}}
+	// 不同用户共享全局限速
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, user := range []string{"alice", "bob"} {
+		client, target := forwardForTest(t, s, user)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transferForTest(t, target, client, 100<<10)
+		}()
+	}
+	wg.Wait()
+	assertDurationForTest(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRateLimiter_AdjustAtRuntime(t *testing.T) {
+	limiter := NewRateLimiter(Bandwidth{}, Bandwidth{}, Bandwidth{Download: 50 << 10})
+	s := &Socks5Server{Config: Config{RateLimiter: limiter}}
+	client, target := forwardForTest(t, s, "alice")
+	// 按 50KB/s 需要 8 秒，传输开始后取消限速，已建立的连接立即生效
+	time.AfterFunc(200*time.Millisecond, func() {
+		limiter.SetPerConnection(Bandwidth{})
+	})
+	if got := transferForTest(t, target, client, 400<<10); got > 2*time.Second {
+		t.Fatalf("want get finished soon after limit removed but got %s", got)
+	}
+
+	// 运行时加上用户限速
+	limiter.SetDefaultUser(Bandwidth{Download: 200 << 10})
+	assertDurationForTest(t, transferForTest(t, target, client, 100<<10), 500*time.Millisecond)
+}
+
+func TestRateLimiter_AdminSetLimits(t *testing.T) {
+	limiter := NewRateLimiter(Bandwidth{}, Bandwidth{}, Bandwidth{})
+	limiter.SetUser("old", Bandwidth{Download: 1})
+	s := &Socks5Server{Config: Config{RateLimiter: limiter, AdminToken: "secret"}}
+	body := `{"global": {"download": 1048576}, "perUser": {"upload": 2048}, "users": {"alice": {"upload": 4096}}}`
+	req := httptest.NewRequest(http.MethodPut, "/ratelimits", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("want get 200 but got %d %s", recorder.Code, recorder.Body)
+	}
+	want := RateLimits{
+		Global:  Bandwidth{Download: 1048576},
+		PerUser: Bandwidth{Upload: 2048},
+		Users:   map[string]Bandwidth{"alice": {Upload: 4096}},
+	}
+	if got := limiter.Limits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want get %+v but got %+v", want, got)
+	}
+}
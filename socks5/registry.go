@@ -0,0 +1,118 @@
+package socks5
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionInfo 会话列表中的一项
+type SessionInfo struct {
+	ID         uint64    `json:"id"`
+	User       string    `json:"user"`
+	ClientAddr string    `json:"clientAddr"`
+	Protocol   string    `json:"protocol"`
+	Target     string    `json:"target"`
+	Start      time.Time `json:"start"`
+	// Upload 客户端到目标的字节数，Download 目标到客户端的字节数
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// SessionRegistry 正在处理的客户端连接，用于管理接口查看和断开
+// 各方法的接收者为 nil 时不登记
+type SessionRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[uint64]*session
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: map[uint64]*session{}}
+}
+
+// add 登记会话并分配 id，返回的函数在连接结束时调用
+func (r *SessionRegistry) add(sess *session) func() {
+	if r == nil {
+		return func() {}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	sess.id = r.nextID
+	r.sessions[sess.id] = sess
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.sessions, sess.id)
+	}
+}
+
+// List 所有会话，按 id 排序
+func (r *SessionRegistry) List() []SessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	infos := make([]SessionInfo, 0, len(r.sessions))
+	for _, sess := range r.sessions {
+		infos = append(infos, sess.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Kill 关闭会话的客户端连接，会话不存在时返回 false
+func (r *SessionRegistry) Kill(id uint64) bool {
+	r.mu.Lock()
+	sess, ok := r.sessions[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	sess.kill()
+	return true
+}
+
+// KillUser 关闭用户的所有会话，返回关闭的数量
+func (r *SessionRegistry) KillUser(user string) int {
+	r.mu.Lock()
+	var sessions []*session
+	for _, sess := range r.sessions {
+		if sess.info().User == user {
+			sessions = append(sessions, sess)
+		}
+	}
+	r.mu.Unlock()
+	for _, sess := range sessions {
+		sess.kill()
+	}
+	return len(sessions)
+}
+
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := SessionInfo{
+		ID:       s.id,
+		User:     s.user,
+		Protocol: s.protocol,
+		Target:   s.target,
+		Start:    s.start,
+		Upload:   s.upload.Load(),
+		Download: s.download.Load(),
+	}
+	if s.clientAddr != nil {
+		info.ClientAddr = s.clientAddr.String()
+	}
+	return info
+}
+
+// kill 关闭客户端连接，转发随之结束
+func (s *session) kill() {
+	info := s.info()
+	slog.Warn("session killed", "id", info.ID, "user", info.User, "remoteAddr", info.ClientAddr, "target", info.Target)
+	s.setReason("killed")
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,121 @@
+package socks5
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// adminForTest 调用管理接口，返回状态码和响应
+func adminForTest(t *testing.T, handler http.Handler, method, path, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestSocks5Server_Sessions(t *testing.T) {
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{
+		AllowPrivateDestinations: true,
+		Method:                   MethodUserPasswd,
+		Timeout:                  time.Second,
+		CheckAuthFunc: func(userName, passwd string) bool {
+			return passwd == "123456"
+		},
+		Sessions:   NewSessionRegistry(),
+		AdminToken: "secret",
+	}}
+	proxyAddr := serveForTest(t, s)
+	admin := s.AdminHandler()
+
+	conns := map[string][]net.Conn{}
+	for _, user := range []string{"alice", "alice", "bob"} {
+		dialer := &Dialer{ProxyAddr: proxyAddr, Username: user, Passwd: "123456", Timeout: 5 * time.Second}
+		conn, err := dialer.Dial("tcp", target.String())
+		if err != nil {
+			t.Fatalf("want get err == nil but got %s", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+			t.Fatalf("io.ReadFull error %s", err)
+		}
+		conns[user] = append(conns[user], conn)
+	}
+
+	if code, _ := adminForTest(t, admin, http.MethodGet, "/sessions", ""); code != http.StatusUnauthorized {
+		t.Fatalf("want get 401 but got %d", code)
+	}
+	if code, _ := adminForTest(t, admin, http.MethodGet, "/sessions", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("want get 401 but got %d", code)
+	}
+	code, body := adminForTest(t, admin, http.MethodGet, "/sessions", "secret")
+	var sessions []SessionInfo
+	if err := json.Unmarshal([]byte(body), &sessions); code != http.StatusOK || err != nil || len(sessions) != 3 {
+		t.Fatalf("want get 3 sessions but got %d %s", code, body)
+	}
+	bob := sessions[2]
+	if bob.User != "bob" || bob.Protocol != "socks5" || bob.Target != target.String() || bob.Upload != 4 || bob.Download != 4 || bob.ClientAddr != conns["bob"][0].LocalAddr().String() {
+		t.Fatalf("want get bob's session but got %+v", bob)
+	}
+
+	// 断开单个会话
+	if code, _ := adminForTest(t, admin, http.MethodDelete, fmt.Sprintf("/sessions/%d", bob.ID), "secret"); code != http.StatusOK {
+		t.Fatalf("want get 200 but got %d", code)
+	}
+	if n, err := conns["bob"][0].Read(make([]byte, 1)); err == nil {
+		t.Fatalf("want get connection closed but got %d bytes", n)
+	}
+	if code, _ := adminForTest(t, admin, http.MethodDelete, "/sessions/12345", "secret"); code != http.StatusNotFound {
+		t.Fatalf("want get 404 but got %d", code)
+	}
+
+	// 断开用户的所有会话
+	if code, body := adminForTest(t, admin, http.MethodDelete, "/users/alice/sessions", "secret"); code != http.StatusOK || body != "{\"killed\":2}\n" {
+		t.Fatalf("want get 2 killed but got %d %s", code, body)
+	}
+	for _, conn := range conns["alice"] {
+		if n, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("want get connection closed but got %d bytes", n)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(s.Config.Sessions.List()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want get no sessions but got %+v", s.Config.Sessions.List())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdminHandler_RequireToken(t *testing.T) {
+	s := &Socks5Server{Config: Config{Sessions: NewSessionRegistry()}}
+	admin := s.AdminHandler()
+	// 未设置 token 时所有接口都不可用
+	for _, path := range []string{"/sessions", "/lockouts", "/usage"} {
+		if code, _ := adminForTest(t, admin, http.MethodGet, path, ""); code != http.StatusForbidden {
+			t.Fatalf("want get 403 for %s but got %d", path, code)
+		}
+	}
+	s.Config.AdminToken = "secret"
+	if code, _ := adminForTest(t, admin, http.MethodGet, "/lockouts", ""); code != http.StatusUnauthorized {
+		t.Fatalf("want get 401 but got %d", code)
+	}
+	if code, _ := adminForTest(t, admin, http.MethodGet, "/lockouts", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("want get 401 but got %d", code)
+	}
+	if code, _ := adminForTest(t, admin, http.MethodGet, "/lockouts", "secret"); code != http.StatusOK {
+		t.Fatalf("want get 200 but got %d", code)
+	}
+}
@@ -3,12 +3,18 @@ package socks5
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Server interface {
@@ -17,40 +23,115 @@ type Server interface {
 
 type Socks5Server struct {
 	Address    string
-	Port       int16
+	Port       int
 	IsServer   bool
 	RemoteAddr string
-	RemotePort int16
+	RemotePort int
 	Config     Config
+	// TLS 不为 nil 时监听端口使用 TLS
+	TLS *TLSServerConfig
+
+	// rejectedClients 因来源地址被拒绝的连接数
+	rejectedClients atomic.Int64
+	graceful        graceful
 }
 
 func (s *Socks5Server) String() string {
 	return fmt.Sprintf("loacal: %s:%d; remote : %s:%d; %+v ", s.Address, s.Port, s.RemoteAddr, s.RemotePort, s.Config)
 }
 
+// Run 监听端口并处理连接，直到调用 Shutdown
 func (s *Socks5Server) Run() error {
-	address := fmt.Sprintf("%s:%d", s.Address, s.Port)
+	return s.ListenAndServe(context.Background())
+}
+
+// ListenAndServe 监听端口（设置了 TLS 时使用 TLS）并处理连接，ctx 取消时停止接受新连接
+func (s *Socks5Server) ListenAndServe(ctx context.Context) error {
 	slog.Info("Socks5Server start ...", "Socks5Server", s)
-	listen, err := net.Listen("tcp", address)
+	listen, err := s.listen()
 	if err != nil {
 		slog.Error("start server error", "err", err)
-		log.Fatalln("start server error", err)
 		return err
 	}
-	defer listen.Close()
+	return s.Serve(ctx, listen)
+}
+
+// Shutdown 停止接受新连接，等待正在处理的连接结束；ctx 结束时强制关闭剩余连接并返回 ctx.Err()
+func (s *Socks5Server) Shutdown(ctx context.Context) error {
+	return s.graceful.shutdown(ctx)
+}
+
+// listen 监听端口，设置了 TLS 时返回 TLS 监听
+func (s *Socks5Server) listen() (net.Listener, error) {
+	address := fmt.Sprintf("%s:%d", s.Address, s.Port)
+	var tlsConfig *tls.Config
+	if s.TLS != nil {
+		var err error
+		if tlsConfig, err = s.TLS.NewTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listen = tls.NewListener(listen, tlsConfig)
+	}
+	return listen, nil
+}
+
+// Serve 在 listen 上接受连接并处理，listen 不会再包装 TLS；
+// ctx 取消时返回 ctx.Err()，调用 Shutdown 后返回 ErrServerClosed，已建立的连接不受 ctx 影响
+func (s *Socks5Server) Serve(ctx context.Context, listen net.Listener) error {
+	queueCtx, stop, err := s.graceful.start(ctx, listen)
+	if err != nil {
+		return err
+	}
+	defer stop()
 	for {
-		clientConn, err := listen.Accept()
+		clientConn, err := s.graceful.accept(ctx, listen)
+		if err != nil {
+			return err
+		}
+		s.Config.Metrics.acceptedConn()
+		// 在读取任何数据（包括 TLS 握手）之前按来源地址过滤
+		if !s.Config.clientAllowed(clientConn.RemoteAddr()) {
+			s.rejectedClients.Add(1)
+			s.Config.Metrics.rejectedConn("client_filter")
+			slog.Warn("client rejected", "remoteAddr", clientConn.RemoteAddr())
+			clientConn.Close()
+			continue
+		}
+		// 全局并发上限在此排队，期间不接受新连接；ctx 取消或 Shutdown 时停止排队
+		releaseGlobal, err := s.Config.ConnLimiter.admitGlobal(queueCtx, clientConn)
 		if err != nil {
-			slog.Error("start server listen error", "err", err)
-			log.Fatalln("start server listen error", err)
+			s.Config.Metrics.rejectedConn("conn_limit")
+			clientConn.Close()
 			continue
 		}
+		done := s.graceful.trackConn(clientConn)
+		if done == nil {
+			releaseGlobal()
+			clientConn.Close()
+			return ErrServerClosed
+		}
 		go func() {
+			defer done()
+			defer releaseGlobal()
+			defer s.Config.Metrics.sessionStarted()()
 			defer func() {
 				if err := recover(); err != nil {
 					log.Printf("%v", err)
 				}
 			}()
+			releaseIP, err := s.Config.ConnLimiter.admitIP(clientConn)
+			if err != nil {
+				s.Config.Metrics.rejectedConn("conn_limit")
+				clientConn.Close()
+				return
+			}
+			defer releaseIP()
 			err = s.handleConn(clientConn, &s.Config)
 			if err != nil {
 				slog.Error("handleConn error", "remoteAddr", clientConn.RemoteAddr(), "err", err)
@@ -61,23 +142,99 @@ func (s *Socks5Server) Run() error {
 
 }
 
+// RejectedClients 因来源地址不在允许列表或在拒绝列表中而被关闭的连接数
+func (s *Socks5Server) RejectedClients() int64 {
+	return s.rejectedClients.Load()
+}
+
+// clientAllowed 来源地址在 DeniedClients 中时拒绝，设置了 AllowedClients 时必须在其中
+func (c *Config) clientAllowed(addr net.Addr) bool {
+	if len(c.DeniedClients) > 0 {
+		ip, ok := addrIP(addr)
+		if ok && prefixesContain(c.DeniedClients, ip) {
+			return false
+		}
+	}
+	return SourceNetworks(c.AllowedClients).AllowSource(addr)
+}
+
 func (s *Socks5Server) handleConn(conn net.Conn, config *Config) error {
 	defer conn.Close()
+	// TLS 握手需在超时时间内完成，避免明文或半开的连接一直占用
+	var certIdentity string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if config.Timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(config.Timeout))
+		}
+		err := tlsConn.Handshake()
+		config.Metrics.handshake("tls", err)
+		if err != nil {
+			return err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		// 已校验的客户端证书作为身份，可替代用户名密码认证
+		if certIdentity = tlsClientIdentity(tlsConn); certIdentity != "" {
+			if config.CheckCertFunc != nil && !config.CheckCertFunc(certIdentity) {
+				err := fmt.Errorf("client cert identity %q not allowed", certIdentity)
+				config.Metrics.authResult("tlscert", err)
+				return err
+			}
+			slog.Debug("client cert identity", "user", certIdentity, "remoteAddr", conn.RemoteAddr())
+		}
+	}
 	reader := bufio.NewReader(conn)
+	// 之后从 conn 读取也要经过 reader，避免丢失 reader 中已缓存的数据（协商、请求和数据可能在一次读取中到达）
+	conn = &bufferedConn{Conn: conn, reader: reader}
+	// 根据第一个字节的版本号区分 SOCKS4 和 SOCKS5
+	ver, err := reader.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch ver[0] {
+	case Socks4:
+		if !config.EnableSocks4 && !config.EnableSocks4a {
+			return errors.New("socks4 not enabled")
+		}
+		return s.handleSocks4(conn, reader, config, certIdentity)
+	case Socks5:
+		if config.DisableSocks5 {
+			return errors.New("socks5 not enabled")
+		}
+	default:
+		// 同一端口同时提供 HTTP 代理
+		if !config.DisableHTTP && isHTTPRequest(reader) {
+			return s.handleHTTP(conn, reader, config, certIdentity)
+		}
+	}
 	// 协商
-	if err := auth(conn, config, reader); err != nil {
+	user, err := auth(conn, config, reader, certIdentity)
+	config.Metrics.handshake("socks5", err)
+	if err != nil {
 		return err
 	}
+	slog.Debug("auth success", "user", user, "remoteAddr", conn.RemoteAddr())
 	// 请求并转发
-	return s.request(conn, reader)
+	sess := newSession(conn, user, "socks5")
+	defer config.Sessions.add(sess)()
+	return s.request(conn, reader, sess)
+
+}
+
+// bufferedConn 读取经过 bufio.Reader 的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
 
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
 }
 
 func NewRequestMessageFromClient(conn io.Reader) (*RequestMessage, error) {
-	var buff = make([]byte, 4)
-	_, err := conn.Read(buff)
+	var buff = make([]byte, 3)
+	_, err := io.ReadFull(conn, buff)
 	if err != nil {
-		slog.Error("NewRequestMessageFromClient conn.Read(buff) error", "conn", conn)
+		slog.Error("NewRequestMessageFromClient io.ReadFull(buff) error", "err", err)
 		return nil, err
 	}
 	ver := buff[0]
@@ -87,86 +244,165 @@ func NewRequestMessageFromClient(conn io.Reader) (*RequestMessage, error) {
 	}
 	command := buff[1]
 	rsv := buff[2]
-	addressType := buff[3]
-	var address string
-	switch addressType {
-	case AddressTypeIPv6:
-		//return nil, errors.New("AddressTypeIPv6 Not Supported")
-		buff = make([]byte, 16)
-		_, err = io.ReadFull(conn, buff)
+	addressType, address, err := readAddress(conn)
+	if err != nil {
+		return nil, err
+	}
+	_, port, err := splitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	requestMessage := RequestMessage{
+		Ver:         Socks5,
+		Command:     command,
+		Rsv:         rsv,
+		AddressType: addressType,
+		Address:     address,
+		Port:        port,
+	}
+	return &requestMessage, nil
+}
+
+// Bytes 编码请求报文，地址类型由 Address 决定
+func (m *RequestMessage) Bytes() ([]byte, error) {
+	host, port, err := splitHostPort(m.Address)
+	if err != nil {
+		return nil, err
+	}
+	buff := []byte{m.Ver, m.Command, m.Rsv}
+	return appendAddress(buff, host, port)
+}
+
+// NewReplyMessage 构造请求回复，BND.ADDR 和 BND.PORT 取自 bindAddr，按地址形式选择 IPv4、IPv6 或域名类型；bindAddr 为 nil 时为 0.0.0.0:0
+func NewReplyMessage(replyType ReplyType, bindAddr net.Addr) *ReplyMessage {
+	replyMessage := ReplyMessage{
+		Ver:         Socks5,
+		Reply:       replyType,
+		Rsv:         RSV,
+		AddressType: AddressTypeIPv4,
+		Address:     net.IPv4zero.String(),
+	}
+	if bindAddr == nil {
+		return &replyMessage
+	}
+	var ip net.IP
+	var port uint16
+	switch addr := bindAddr.(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP, uint16(addr.Port)
+	case *net.UDPAddr:
+		ip, port = addr.IP, uint16(addr.Port)
+	default:
+		host, p, err := splitHostPort(addr.String())
 		if err != nil {
-			return nil, err
+			return &replyMessage
 		}
-		ip := net.IPAddr{
-			IP: buff,
+		port = p
+		if ip = net.ParseIP(host); ip == nil {
+			replyMessage.AddressType = AddressTypeDomain
+			replyMessage.Address = host
+			replyMessage.Port = port
+			return &replyMessage
 		}
-		_, err := io.ReadFull(conn, buff[0:2])
-		if err != nil {
-			return nil, err
-		}
-		port := (int16(buff[0]) << 8) + int16(buff[1])
-		address = fmt.Sprintf("%s:%d", ip, port)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		replyMessage.Address = ip4.String()
+	} else if ip != nil {
+		replyMessage.AddressType = AddressTypeIPv6
+		replyMessage.Address = ip.String()
+	}
+	replyMessage.Port = port
+	return &replyMessage
+}
+
+// Bytes 编码回复报文
+func (m *ReplyMessage) Bytes() ([]byte, error) {
+	buff := []byte{m.Ver, m.Reply, m.Rsv, m.AddressType}
+	switch m.AddressType {
 	case AddressTypeIPv4:
-		_, err = io.ReadFull(conn, buff)
-		if err != nil {
-			return nil, err
+		ip := net.ParseIP(m.Address).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("ReplyMessage invalid IPv4 address %q", m.Address)
 		}
-		ip := net.IPv4(buff[0], buff[1], buff[2], buff[3]).String()
-		_, err := io.ReadFull(conn, buff[0:2])
-		if err != nil {
-			return nil, err
+		buff = append(buff, ip...)
+	case AddressTypeIPv6:
+		ip := net.ParseIP(m.Address).To16()
+		if ip == nil {
+			return nil, fmt.Errorf("ReplyMessage invalid IPv6 address %q", m.Address)
 		}
-		port := (int16(buff[0]) << 8) + int16(buff[1])
-		address = fmt.Sprintf("%s:%d", ip, port)
+		buff = append(buff, ip...)
 	case AddressTypeDomain:
-		_, err = io.ReadFull(conn, buff[0:1])
-		if err != nil {
-			return nil, err
-		}
-		domainLen := buff[0]
-		buff = make([]byte, domainLen)
-		io.ReadFull(conn, buff)
-		domain := string(buff)
-		_, err := io.ReadFull(conn, buff[0:2])
-		if err != nil {
-			return nil, err
+		if len(m.Address) == 0 || len(m.Address) > 255 {
+			return nil, fmt.Errorf("ReplyMessage invalid domain length %d", len(m.Address))
 		}
-		port := (int16(buff[0]) << 8) + int16(buff[1])
-		address = fmt.Sprintf("%s:%d", domain, port)
+		buff = append(buff, byte(len(m.Address)))
+		buff = append(buff, m.Address...)
+	default:
+		return nil, fmt.Errorf("ReplyMessage address type %d not supported", m.AddressType)
+	}
+	return binary.BigEndian.AppendUint16(buff, m.Port), nil
+}
 
+// WriteTo 将回复报文写入连接
+func (m *ReplyMessage) WriteTo(w io.Writer) (int64, error) {
+	buff, err := m.Bytes()
+	if err != nil {
+		return 0, err
 	}
+	n, err := w.Write(buff)
+	return int64(n), err
+}
 
-	requestMessage := RequestMessage{
-		Ver:         Socks5,
-		Command:     command,
-		Rsv:         rsv,
+// NewReplyMessageFromServer 从连接中读取服务端的请求回复
+func NewReplyMessageFromServer(conn io.Reader) (*ReplyMessage, error) {
+	var buff = make([]byte, 3)
+	if _, err := io.ReadFull(conn, buff); err != nil {
+		return nil, err
+	}
+	if buff[0] != Socks5 {
+		return nil, errors.New("NewReplyMessageFromServer protocol not supported")
+	}
+	addressType, address, err := readAddress(conn)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	replyMessage := ReplyMessage{
+		Ver:         buff[0],
+		Reply:       buff[1],
+		Rsv:         buff[2],
 		AddressType: addressType,
-		Address:     address,
+		Address:     host,
+		Port:        port,
 	}
-	return &requestMessage, nil
+	return &replyMessage, nil
 }
 
 func NewRequestReplyFailMessage(conn io.Writer, replyType ReplyType) error {
 	// 1  |  1  | X'00' |  1   | Variable |    2
-	//TODO  address port :127,0,0,1,0x11,0x39
-
-	buff := []byte{Socks5, replyType, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0}
-	_, err := conn.Write(buff)
+	_, err := NewReplyMessage(replyType, nil).WriteTo(conn)
 	return err
 
 }
 
+// NewRequestReplySuccessMessage 回复请求成功，BND.ADDR 和 BND.PORT 为 0.0.0.0:0；
+// 需要回复实际绑定的地址时使用 NewReplyMessage(ReplySuccess, bindAddr).WriteTo(conn)
 func NewRequestReplySuccessMessage(conn io.Writer) error {
 	// 1  |  1  | X'00' |  1   | Variable |    2
-	//TODO  address port :127,0,0,1,0x11,0x39
-	buff := []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0}
-	_, err := conn.Write(buff)
+	_, err := NewReplyMessage(ReplySuccess, nil).WriteTo(conn)
 	return err
 
 }
+
+// NewRequestReplySuccessMessageV2 回复请求成功，addrAndPortBytes 为 IPv4 的 BND.ADDR 和 BND.PORT 原始字节
+//
+// Deprecated: ATYP 固定为 IPv4，使用 NewReplyMessage(ReplySuccess, bindAddr).WriteTo(conn)
 func NewRequestReplySuccessMessageV2(conn io.Writer, addrAndPortBytes []byte) error {
 	// 1  |  1  | X'00' |  1   | Variable |    2
-	//TODO  address port :127,0,0,1,0x11,0x39
 	buff := []byte{Socks5, ReplySuccess, RSV, AddressTypeIPv4}
 	buff = append(buff, addrAndPortBytes...)
 	_, err := conn.Write(buff)
@@ -175,61 +411,104 @@ func NewRequestReplySuccessMessageV2(conn io.Writer, addrAndPortBytes []byte) er
 }
 
 // request
-func (s *Socks5Server) request(conn io.ReadWriter, reader *bufio.Reader) error {
+func (s *Socks5Server) request(conn net.Conn, reader *bufio.Reader, sess *session) (err error) {
 	// 获取请求信息，处理客户端告知目标地址和Command，即客户端已经告知地址了
 	message, err := NewRequestMessageFromClient(reader)
 	if err != nil {
+		if errors.Is(err, ErrAddressTypeNotSupported) {
+			NewRequestReplyFailMessage(conn, ReplyNotSupportedAddressType)
+		}
 		return err
 	}
 	command := message.Command
+	sess.beginTunnel(commandName(command), message.Address)
+	defer func() { s.logTunnel(sess, err) }()
+	release, err := s.Config.ConnLimiter.admitUser(sess)
+	if err != nil {
+		NewRequestReplyFailMessage(conn, ReplyCommonFail)
+		return err
+	}
+	defer release()
 
 	switch command {
 	case CommandConnect:
-		return s.handleTcp(conn, message)
+		return s.handleTcp(conn, sess, message)
 	case CommandUdpAssociate:
-		return handleUdp()
+		return s.handleUdp(conn, sess, message)
 	case CommandBind:
-		// ReplyNotSupportedCmd
-		NewRequestReplyFailMessage(conn, ReplyNotSupportedCmd)
-		return nil
+		return s.handleBind(conn, sess, message)
 	}
-	return nil
+	// RFC 1928 §6 不支持的命令回复 0x07 后关闭连接
+	NewRequestReplyFailMessage(conn, ReplyNotSupportedCmd)
+	return fmt.Errorf("%w: 0x%02x", ErrCommandNotSupported, command)
 
 }
 
 // handleTcp
-func (s5 *Socks5Server) handleTcp(conn io.ReadWriter, message *RequestMessage) error {
+func (s5 *Socks5Server) handleTcp(conn io.ReadWriter, sess *session, message *RequestMessage) error {
 	// 作为远程服务端代理进行最终目标请求并转发
 	if s5.IsServer {
-		tagertAdress := message.Address
-		slog.Debug("作为远程服务端代理进行最终目标请求并转发", "tagertAdress", tagertAdress, "Timeout", s5.Config.Timeout)
-		targetConn, err := net.DialTimeout("tcp", tagertAdress, s5.Config.Timeout)
-		// targetConn, err := net.Dial("tcp", tagertAdress)
+		targetConn, err := s5.dialTarget(sess, message.Address)
 		if err != nil {
-			// 返回远程网络不可达错误
-			slog.Error("net.DialTimeout error", "tagertAdress", tagertAdress, "Timeout", s5.Config.Timeout, "err", err)
-			NewRequestReplyFailMessage(conn, ReplyNetworkNotArrived)
+			// 按错误类型返回对应的回复码
+			NewRequestReplyFailMessage(conn, dialErrorToReply(err))
 			return err
 		}
 		slog.Debug("作为远程服务端代理请求目标服务器后,给与客户端回复请求成功")
-		NewRequestReplySuccessMessage(conn)
+		NewReplyMessage(ReplySuccess, targetConn.LocalAddr()).WriteTo(conn)
 		// 数据转发 （协同客户端一起实现）
 		// 1 直接复用客户端认证连接进行转发 conn,目前的实现方式
 		// 2 TODO  开启端口转发监听 等待客户端连接
-		return s5.forward(conn, targetConn)
+		return s5.forward(sess, conn, targetConn)
 	}
 	return nil
 
 }
 
-// handleUdp
-func handleUdp() error {
-	log.Printf("TODO implements handleUdp  \n")
-	return nil
+// dialTarget 连接最终目标，内网地址拦截和访问控制在解析域名之后、建立连接之前按实际连接的 IP 判断
+func (s5 *Socks5Server) dialTarget(sess *session, tagertAdress string) (net.Conn, error) {
+	slog.Debug("作为远程服务端代理进行最终目标请求并转发", "tagertAdress", tagertAdress, "Timeout", s5.Config.Timeout)
+	targetConn, err := s5.dialTargetConn(sess, tagertAdress)
+	s5.Config.Metrics.connectResult(dialErrorToReply(err))
+	return targetConn, err
+}
+
+// dialTargetConn dialTarget 的实现，dialTarget 在此基础上记录 CONNECT 结果的指标
+func (s5 *Socks5Server) dialTargetConn(sess *session, tagertAdress string) (net.Conn, error) {
+	sess.setTarget(tagertAdress)
+	if err := s5.checkQuota(sess); err != nil {
+		return nil, err
+	}
+	target, err := newACLTarget(CommandConnect, tagertAdress)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout: s5.Config.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			// Dialer 可能并行尝试 IPv4 和 IPv6 地址，每次尝试使用各自的副本
+			attempt := target
+			attempt.ip = addrPort.Addr().Unmap()
+			sess.setResolvedIP(attempt.ip.String())
+			return s5.checkDestination(sess, attempt)
+		},
+	}
+	start := time.Now()
+	targetConn, err := dialer.Dial("tcp", tagertAdress)
+	s5.Config.Metrics.dialed(time.Since(start))
+	if err != nil {
+		slog.Error("net.DialTimeout error", "tagertAdress", tagertAdress, "Timeout", s5.Config.Timeout, "reply", dialErrorToReply(err), "err", err)
+		return nil, err
+	}
+	return targetConn, nil
 }
 
 // 转发
-func (s5 *Socks5Server) forward(conn io.ReadWriter, dest io.ReadWriteCloser) error {
+func (s5 *Socks5Server) forward(sess *session, conn io.ReadWriter, dest io.ReadWriteCloser) error {
 	defer dest.Close()
 	//go io.Copy(dest, conn)
 	//_, err := io.Copy(conn, dest)
@@ -237,17 +516,52 @@ func (s5 *Socks5Server) forward(conn io.ReadWriter, dest io.ReadWriteCloser) err
 	// 2. 通过启动两个单向数据转发子协程实现双向转发转发
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	sess.setEstablished()
+	var upload, download io.Reader = conn, dest
+	if limiter := s5.Config.RateLimiter; limiter != nil {
+		var user string
+		if sess != nil {
+			user = sess.user
+		}
+		uploadLimiters, downloadLimiters, release := limiter.acquire(user)
+		defer release()
+		upload = &rateLimitedReader{ctx: ctx, reader: conn, limiters: uploadLimiters}
+		download = &rateLimitedReader{ctx: ctx, reader: dest, limiters: downloadLimiters}
+	}
+	if accounting := s5.Config.Accounting; accounting != nil && sess != nil && sess.user != "" {
+		user := sess.user
+		defer accounting.track(user, cancel)()
+		upload = &countingReader{ctx: ctx, reader: upload, count: func(n int64) { accounting.count(user, Usage{Upload: n}) }}
+		download = &countingReader{ctx: ctx, reader: download, count: func(n int64) { accounting.count(user, Usage{Download: n}) }}
+	}
+	if metrics := s5.Config.Metrics; metrics != nil {
+		upload = &countingReader{ctx: ctx, reader: upload, count: metrics.countBytes("upload")}
+		download = &countingReader{ctx: ctx, reader: download, count: metrics.countBytes("download")}
+	}
+	if (s5.Config.Sessions != nil || s5.Config.AccessLog != nil) && sess != nil {
+		upload = &countingReader{ctx: ctx, reader: upload, count: sess.countBytes("upload")}
+		download = &countingReader{ctx: ctx, reader: download, count: sess.countBytes("download")}
+	}
 	go func() {
 		// dest 内容复制到客户端连接conn
-		_, _ = io.Copy(conn, dest)
+		_, _ = io.Copy(conn, download)
+		// ctx 已结束说明是被外部断开，不是目标关闭
+		if ctx.Err() == nil {
+			sess.setReason("target closed")
+		}
 		cancel()
 	}()
 	go func() {
-		// 等价： 0ioconn.WriteTo(dest).
-		_, _ = io.Copy(dest, conn)
+		// 客户端连接 conn 内容复制到 dest
+		_, _ = io.Copy(dest, upload)
+		if ctx.Err() == nil {
+			sess.setReason("client closed")
+		}
 		cancel()
 	}()
 
 	<-ctx.Done()
+	// 两个方向都没有结束，是超出配额被断开
+	sess.setReason("quota exceeded")
 	return nil
 }
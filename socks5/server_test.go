@@ -0,0 +1,130 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// testServer Socks5Server 和 Client
+type testServer interface {
+	Serve(ctx context.Context, listen net.Listener) error
+}
+
+// startForTest 在随机端口上运行 server.Serve，Socks5Server 设置了 TLS 时监听 TLS；测试结束时关闭监听，返回监听地址和 Serve 的返回值
+func startForTest(t *testing.T, ctx context.Context, server testServer) (string, chan error) {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen error %s", err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	if s, ok := server.(*Socks5Server); ok && s.TLS != nil {
+		tlsConfig, err := s.TLS.NewTLSConfig()
+		if err != nil {
+			t.Fatalf("NewTLSConfig error %s", err)
+		}
+		listen = tls.NewListener(listen, tlsConfig)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listen) }()
+	return listen.Addr().String(), served
+}
+
+// serveForTest 在随机端口上运行服务端或本地客户端直到测试结束，返回监听地址
+func serveForTest(t *testing.T, server testServer) string {
+	t.Helper()
+	address, _ := startForTest(t, context.Background(), server)
+	return address
+}
+
+func TestConfig_clientAllowed(t *testing.T) {
+	office := netip.MustParsePrefix("198.51.100.0/24")
+	tests := []struct {
+		name   string
+		config Config
+		ip     string
+		want   bool
+	}{
+		{"no lists", Config{}, "203.0.113.1", true},
+		{"in allowed", Config{AllowedClients: []netip.Prefix{office}}, "198.51.100.7", true},
+		{"not in allowed", Config{AllowedClients: []netip.Prefix{office}}, "203.0.113.1", false},
+		{"ipv4-mapped in allowed", Config{AllowedClients: []netip.Prefix{office}}, "::ffff:198.51.100.7", true},
+		{"denied", Config{DeniedClients: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}}, "203.0.113.1", false},
+		{"deny wins over allow", Config{AllowedClients: []netip.Prefix{office}, DeniedClients: []netip.Prefix{netip.MustParsePrefix("198.51.100.7/32")}}, "198.51.100.7", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 1234}
+			if got := tt.config.clientAllowed(addr); got != tt.want {
+				t.Fatalf("want get %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSocks5Server_RejectedClients(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Address: "127.0.0.1", Config: Config{
+		Method:         MethodNoAuth,
+		Timeout:        time.Second,
+		AllowedClients: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		DeniedClients:  []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+	}}
+	listen, err := s.listen()
+	if err != nil {
+		t.Fatalf("listen error %s", err)
+	}
+	defer listen.Close()
+	go s.Serve(context.Background(), listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{Socks5, 1, MethodNoAuth})
+	if n, err := conn.Read(make([]byte, 2)); err != io.EOF && n != 0 {
+		t.Fatalf("want get connection closed but got %d bytes %v", n, err)
+	}
+	if got := s.RejectedClients(); got != 1 {
+		t.Fatalf("want get 1 rejected client but got %d", got)
+	}
+}
+
+func TestSocks5Server_UnsupportedCommand(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{Timeout: time.Second}}
+	conn, err := net.Dial("tcp", serveForTest(t, s))
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{Socks5, 1, MethodNoAuth, Socks5, 0x09, RSV, AddressTypeIPv4, 1, 2, 3, 4, 0, 80})
+	buff := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[3] != ReplyNotSupportedCmd {
+		t.Fatalf("want get reply 0x07 but got %v %v", buff, err)
+	}
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF || n != 0 {
+		t.Fatalf("want get connection closed but got %d bytes %v", n, err)
+	}
+}
+
+func TestSocks5Server_UnsupportedAddressType(t *testing.T) {
+	s := &Socks5Server{IsServer: true, Config: Config{Timeout: time.Second}}
+	conn, err := net.Dial("tcp", serveForTest(t, s))
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{Socks5, 1, MethodNoAuth, Socks5, CommandConnect, RSV, 0x09, 1, 2, 3, 4, 0, 80})
+	buff := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[3] != ReplyNotSupportedAddressType {
+		t.Fatalf("want get reply 0x08 but got %v %v", buff, err)
+	}
+}
@@ -0,0 +1,120 @@
+package socks5
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session 一次客户端连接认证后的上下文，随请求传递给访问控制等处理
+type session struct {
+	// user 认证得到的身份（用户名或 TLS 客户端证书的身份），无需认证时为空
+	// HTTP 代理中每个请求可能不同，由处理连接的协程通过 setUser 修改
+	user string
+	// clientAddr 客户端地址
+	clientAddr net.Addr
+	// protocol socks5、socks4 或 http
+	protocol string
+
+	// 以下用于会话列表，id 在登记时分配
+	id    uint64
+	conn  net.Conn
+	start time.Time
+	mu    sync.Mutex
+	// target 请求的目标地址，UDP 为最近一个数据报的目标
+	target   string
+	upload   atomic.Int64
+	download atomic.Int64
+
+	// 以下用于访问日志，记录当前的隧道（一次请求），每个请求开始时由 beginTunnel 重置
+	command string
+	// resolvedIP 实际连接的 IP
+	resolvedIP string
+	// established 已回复请求成功并开始转发
+	established bool
+	// reason 隧道结束的原因，只记录第一个
+	reason      string
+	tunnelStart time.Time
+	// tunnelUpload、tunnelDownload 隧道开始时连接已转发的字节数
+	tunnelUpload   int64
+	tunnelDownload int64
+}
+
+func newSession(conn net.Conn, user, protocol string) *session {
+	return &session{user: user, clientAddr: conn.RemoteAddr(), protocol: protocol, conn: conn, start: time.Now()}
+}
+
+func (s *session) setUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// userName 加锁读取 user，HTTP 代理的 Transport 在其他协程中连接目标时使用
+func (s *session) userName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.user
+}
+
+// beginTunnel 开始一个隧道，command 为 connect、bind、udp 或 http
+func (s *session) beginTunnel(command, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.command = command
+	s.target = address
+	s.resolvedIP = ""
+	s.established = false
+	s.reason = ""
+	s.tunnelStart = time.Now()
+	s.tunnelUpload = s.upload.Load()
+	s.tunnelDownload = s.download.Load()
+}
+
+func (s *session) setResolvedIP(ip string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolvedIP = ip
+}
+
+func (s *session) setEstablished() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.established = true
+}
+
+// setReason 记录隧道结束的原因，已有原因时忽略
+func (s *session) setReason(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+}
+
+func (s *session) setTarget(target string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = target
+}
+
+// countBytes 记录转发的字节数，direction 为 upload 或 download
+func (s *session) countBytes(direction string) func(n int64) {
+	if direction == "upload" {
+		return func(n int64) { s.upload.Add(n) }
+	}
+	return func(n int64) { s.download.Add(n) }
+}
@@ -0,0 +1,140 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed 调用 Shutdown 之后 Serve 返回的错误
+var ErrServerClosed = errors.New("socks5: server closed")
+
+// shutdownPollInterval Shutdown 检查连接是否处理完的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// graceful 记录监听和正在处理的连接，Shutdown 时停止接受新连接并等待连接处理完
+type graceful struct {
+	mu     sync.Mutex
+	closed bool
+	// listeners 监听及其排队 context 的取消函数
+	listeners map[net.Listener]context.CancelFunc
+	conns     map[net.Conn]struct{}
+}
+
+// start 登记监听，ctx 取消时关闭监听；返回的 queueCtx 在 ctx 取消或 Shutdown 时结束，用于中断接受连接循环中的排队，
+// 返回的函数在停止接受连接后调用
+func (g *graceful) start(ctx context.Context, listen net.Listener) (queueCtx context.Context, stop func(), err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		listen.Close()
+		return nil, nil, ErrServerClosed
+	}
+	if g.listeners == nil {
+		g.listeners = map[net.Listener]context.CancelFunc{}
+	}
+	queueCtx, cancel := context.WithCancel(ctx)
+	g.listeners[listen] = cancel
+	stopClose := context.AfterFunc(ctx, func() { listen.Close() })
+	return queueCtx, func() {
+		stopClose()
+		cancel()
+		listen.Close()
+		g.untrackListener(listen)
+	}, nil
+}
+
+func (g *graceful) untrackListener(listen net.Listener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.listeners, listen)
+}
+
+// trackConn 登记连接，返回的函数在连接处理完后调用；已关闭时返回 nil
+func (g *graceful) trackConn(conn net.Conn) func() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil
+	}
+	if g.conns == nil {
+		g.conns = map[net.Conn]struct{}{}
+	}
+	g.conns[conn] = struct{}{}
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(g.conns, conn)
+	}
+}
+
+// accept 接受一个连接；ctx 取消或 Shutdown 后返回错误，临时错误时退避重试
+func (g *graceful) accept(ctx context.Context, listen net.Listener) (net.Conn, error) {
+	var delay time.Duration
+	for {
+		conn, err := listen.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		g.mu.Lock()
+		closed := g.closed
+		g.mu.Unlock()
+		if closed {
+			return nil, ErrServerClosed
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return nil, err
+		}
+		// 如文件描述符耗尽，等待后重试
+		if delay == 0 {
+			delay = 5 * time.Millisecond
+		} else if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+		slog.Error("accept failed", "err", err, "retryIn", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// shutdown 关闭所有监听，等待连接处理完；ctx 结束时强制关闭剩余连接并返回 ctx.Err()
+func (g *graceful) shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	for listen, cancel := range g.listeners {
+		listen.Close()
+		cancel()
+	}
+	g.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		g.mu.Lock()
+		active := len(g.conns)
+		g.mu.Unlock()
+		if active == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			g.mu.Lock()
+			slog.Warn("shutdown timeout, force closing connections", "active", len(g.conns))
+			for conn := range g.conns {
+				conn.Close()
+			}
+			g.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pingForTest 通过 conn 发送 ping 并读取回显
+func pingForTest(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func TestSocks5Server_Shutdown(t *testing.T) {
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Timeout: time.Second}}
+	proxyAddr, served := startForTest(t, context.Background(), s)
+	dialer := &Dialer{ProxyAddr: proxyAddr, Timeout: 5 * time.Second}
+	conn, err := dialer.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	defer conn.Close()
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("want get ErrServerClosed but got %v", err)
+	}
+	// 不再接受新连接，已建立的隧道继续转发
+	if _, err := dialer.Dial("tcp", target.String()); err == nil {
+		t.Fatalf("want get err but got nil")
+	}
+	if err := pingForTest(conn); err != nil {
+		t.Fatalf("want get ping but got %s", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("want get Shutdown waiting but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.Close()
+	if err := <-shutdown; err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := s.Serve(context.Background(), &net.TCPListener{}); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("want get ErrServerClosed but got %v", err)
+	}
+}
+
+func TestSocks5Server_ShutdownTimeout(t *testing.T) {
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Timeout: time.Second}}
+	proxyAddr, _ := startForTest(t, context.Background(), s)
+	dialer := &Dialer{ProxyAddr: proxyAddr, Timeout: 5 * time.Second}
+	conn, err := dialer.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	defer conn.Close()
+
+	// 超时后强制关闭未结束的隧道
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want get DeadlineExceeded but got %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("want get connection closed but got %d bytes", n)
+	}
+}
+
+func TestSocks5Server_ShutdownWhileQueued(t *testing.T) {
+	limiter := &ConnLimiter{MaxConns: 1, QueueTimeout: 10 * time.Second}
+	s := &Socks5Server{IsServer: true, Config: Config{Timeout: 5 * time.Second, ConnLimiter: limiter}}
+	proxyAddr, served := startForTest(t, context.Background(), s)
+	// 第一个连接占用全局名额，第二个连接在接受连接的循环中排队
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatalf("net.Dial error %s", err)
+		}
+		defer conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.Stats().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want get 1 waiting but got %+v", limiter.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want get DeadlineExceeded but got %v", err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("want get ErrServerClosed but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want get Serve returned but still queued")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("want get Shutdown returned soon but got %s", elapsed)
+	}
+	if stats := limiter.Stats(); stats.Waiting != 0 {
+		t.Fatalf("want get 0 waiting but got %+v", stats)
+	}
+}
+
+func TestSocks5Server_ServeContextCanceled(t *testing.T) {
+	s := &Socks5Server{IsServer: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	proxyAddr, served := startForTest(t, ctx, s)
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want get context.Canceled but got %v", err)
+	}
+	if conn, err := net.Dial("tcp", proxyAddr); err == nil {
+		conn.Close()
+		t.Fatalf("want get err but got nil")
+	}
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Timeout: time.Second}}
+	c := &Client{RemoteAddr: serveForTest(t, s)}
+	address, served := startForTest(t, context.Background(), c)
+	dialer := &Dialer{ProxyAddr: address, Timeout: 5 * time.Second}
+	conn, err := dialer.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := pingForTest(conn); err != nil {
+		t.Fatalf("want get ping but got %s", err)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("want get ErrServerClosed but got %v", err)
+	}
+}
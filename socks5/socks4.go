@@ -0,0 +1,147 @@
+package socks5
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+// USERID 和 SOCKS4a 域名的最大长度（不含结尾的 NULL）
+const socks4MaxFieldLen = 255
+
+// NewSocks4RequestMessageFromClient 从连接中获取 SOCKS4/SOCKS4a 请求
+func NewSocks4RequestMessageFromClient(reader *bufio.Reader) (*Socks4RequestMessage, error) {
+	var buff = make([]byte, 8)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+	if buff[0] != Socks4 {
+		return nil, errors.New("NewSocks4RequestMessageFromClient protocol not supported")
+	}
+	message := Socks4RequestMessage{
+		Ver:     buff[0],
+		Command: buff[1],
+		Port:    binary.BigEndian.Uint16(buff[2:4]),
+	}
+	copy(message.IP[:], buff[4:8])
+	userId, err := readNullString(reader)
+	if err != nil {
+		return nil, err
+	}
+	message.UserId = userId
+
+	host := net.IP(message.IP[:]).String()
+	// SOCKS4a: DSTIP 为 0.0.0.x（x 不为 0）时，USERID 之后是目标域名
+	if message.IP[0] == 0 && message.IP[1] == 0 && message.IP[2] == 0 && message.IP[3] != 0 {
+		domain, err := readNullString(reader)
+		if err != nil {
+			return nil, err
+		}
+		if domain == "" {
+			return nil, errors.New("NewSocks4RequestMessageFromClient empty domain")
+		}
+		message.Domain = domain
+		host = domain
+	}
+	message.Address = net.JoinHostPort(host, strconv.Itoa(int(message.Port)))
+	return &message, nil
+}
+
+// NewSocks4ReplyMessage 回复 SOCKS4 请求，成功为 0x5A，其余均为 0x5B，bindAddr 只支持 IPv4
+func NewSocks4ReplyMessage(conn net.Conn, replyType ReplyType, bindAddr net.Addr) error {
+	buff := []byte{Socks4ReplyVer, Socks4ReplyRejected, 0, 0, 0, 0, 0, 0}
+	if replyType == ReplySuccess {
+		buff[1] = Socks4ReplyGranted
+	}
+	if tcpAddr, ok := bindAddr.(*net.TCPAddr); ok {
+		if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+			binary.BigEndian.PutUint16(buff[2:4], uint16(tcpAddr.Port))
+			copy(buff[4:8], ip4)
+		}
+	}
+	_, err := conn.Write(buff)
+	return err
+}
+
+// handleSocks4 处理 SOCKS4/SOCKS4a 的 CONNECT 和 BIND，certIdentity 为 TLS 客户端证书的身份
+func (s5 *Socks5Server) handleSocks4(conn net.Conn, reader *bufio.Reader, config *Config, certIdentity string) (err error) {
+	reply := func(replyType ReplyType, bindAddr net.Addr) error {
+		return NewSocks4ReplyMessage(conn, replyType, bindAddr)
+	}
+	message, err := NewSocks4RequestMessageFromClient(reader)
+	if err != nil {
+		return err
+	}
+	if message.Domain != "" && !config.EnableSocks4a {
+		reply(ReplyRegularDenied, nil)
+		return errors.New("socks4a not enabled")
+	}
+	// SOCKS4 只有 USERID 没有密码，需要认证且没有客户端证书时拒绝
+	if certIdentity == "" && !config.noAuthAllowed(conn.RemoteAddr()) {
+		slog.Error("socks4 request rejected, auth required", "userId", message.UserId, "remoteAddr", conn.RemoteAddr())
+		reply(ReplyRegularDenied, nil)
+		err := fmt.Errorf("socks4 can not satisfy auth method: %w", ErrNoAcceptableMethod)
+		config.Metrics.authResult("none", err)
+		config.Metrics.handshake("socks4", err)
+		return err
+	}
+	if certIdentity != "" {
+		config.Metrics.authResult("tlscert", nil)
+	} else {
+		config.Metrics.authResult(methodLabel(MethodNoAuth), nil)
+	}
+	config.Metrics.handshake("socks4", nil)
+	slog.Debug("socks4 request", "command", message.Command, "address", message.Address, "userId", message.UserId)
+	// USERID 未经认证，不作为身份
+	sess := newSession(conn, certIdentity, "socks4")
+	defer config.Sessions.add(sess)()
+	sess.beginTunnel(commandName(message.Command), message.Address)
+	defer func() { s5.logTunnel(sess, err) }()
+	release, err := s5.Config.ConnLimiter.admitUser(sess)
+	if err != nil {
+		reply(ReplyCommonFail, nil)
+		return err
+	}
+	defer release()
+
+	switch message.Command {
+	case CommandConnect:
+		targetConn, err := s5.dialTarget(sess, message.Address)
+		if err != nil {
+			reply(dialErrorToReply(err), nil)
+			return err
+		}
+		if err := reply(ReplySuccess, targetConn.LocalAddr()); err != nil {
+			targetConn.Close()
+			return err
+		}
+		return s5.forward(sess, conn, targetConn)
+	case CommandBind:
+		return s5.bind(conn, sess, message.Address, reply)
+	}
+	reply(ReplyNotSupportedCmd, nil)
+	return fmt.Errorf("socks4 command %d not supported", message.Command)
+}
+
+// readNullString 读取以 NULL 结尾的字符串
+func readNullString(reader *bufio.Reader) (string, error) {
+	var buff []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buff), nil
+		}
+		if len(buff) >= socks4MaxFieldLen {
+			return "", errors.New("readNullString field too long")
+		}
+		buff = append(buff, b)
+	}
+}
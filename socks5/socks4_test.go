@@ -0,0 +1,111 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoForTest 启动 TCP 回显服务，返回监听地址
+func echoForTest(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen error %s", err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listen.Addr().(*net.TCPAddr)
+}
+
+// socks4ConnectForTest 发送 SOCKS4 CONNECT 请求，domain 不为空时使用 SOCKS4a，返回连接和回复
+func socks4ConnectForTest(t *testing.T, address string, target *net.TCPAddr, domain string) (net.Conn, []byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := []byte{Socks4, CommandConnect}
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	if domain != "" {
+		request = append(request, 0, 0, 0, 1)
+	} else {
+		request = append(request, target.IP.To4()...)
+	}
+	request = append(request, "user"...)
+	request = append(request, 0)
+	if domain != "" {
+		request = append(request, domain...)
+		request = append(request, 0)
+	}
+	conn.Write(request)
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return conn, nil
+	}
+	return conn, reply
+}
+
+func TestSocks5Server_Socks4(t *testing.T) {
+	target := echoForTest(t)
+
+	t.Run("test socks4 connect should success", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second, EnableSocks4: true}}
+		conn, reply := socks4ConnectForTest(t, serveForTest(t, s), target, "")
+		if reply == nil || reply[0] != Socks4ReplyVer || reply[1] != Socks4ReplyGranted {
+			t.Fatalf("want get granted but got %v", reply)
+		}
+		conn.Write([]byte("ping"))
+		buff := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buff); err != nil || string(buff) != "ping" {
+			t.Fatalf("want get ping but got %q %s", buff, err)
+		}
+	})
+
+	t.Run("test socks4a connect with domain should success", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second, EnableSocks4a: true}}
+		_, reply := socks4ConnectForTest(t, serveForTest(t, s), target, "localhost")
+		if reply == nil || reply[1] != Socks4ReplyGranted {
+			t.Fatalf("want get granted but got %v", reply)
+		}
+	})
+
+	t.Run("test socks4a should be rejected when only socks4 enabled", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second, EnableSocks4: true}}
+		_, reply := socks4ConnectForTest(t, serveForTest(t, s), target, "localhost")
+		if reply == nil || reply[1] != Socks4ReplyRejected {
+			t.Fatalf("want get rejected but got %v", reply)
+		}
+	})
+
+	t.Run("test socks4 should be rejected when auth required", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodUserPasswd, Timeout: time.Second, EnableSocks4: true}}
+		_, reply := socks4ConnectForTest(t, serveForTest(t, s), target, "")
+		if reply == nil || reply[1] != Socks4ReplyRejected {
+			t.Fatalf("want get rejected but got %v", reply)
+		}
+	})
+
+	t.Run("test socks4 should be closed when disabled", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second}}
+		_, reply := socks4ConnectForTest(t, serveForTest(t, s), target, "")
+		if reply != nil {
+			t.Fatalf("want get connection closed but got %v", reply)
+		}
+	})
+}
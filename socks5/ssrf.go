@@ -0,0 +1,65 @@
+package socks5
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+)
+
+// ErrPrivateDestination 目标为内网、回环、链路本地等地址，默认拒绝
+var ErrPrivateDestination = fmt.Errorf("%w: private destination", ErrAccessDenied)
+
+// privateNetworks 默认禁止代理访问的网络
+var privateNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // 本网络
+	netip.MustParsePrefix("10.0.0.0/8"),     // RFC 1918
+	netip.MustParsePrefix("100.64.0.0/10"),  // CGNAT
+	netip.MustParsePrefix("127.0.0.0/8"),    // 回环
+	netip.MustParsePrefix("169.254.0.0/16"), // 链路本地，包括云主机元数据 169.254.169.254
+	netip.MustParsePrefix("172.16.0.0/12"),  // RFC 1918
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF 协议分配
+	netip.MustParsePrefix("192.168.0.0/16"), // RFC 1918
+	netip.MustParsePrefix("198.18.0.0/15"),  // 基准测试
+	netip.MustParsePrefix("224.0.0.0/4"),    // 组播
+	netip.MustParsePrefix("240.0.0.0/4"),    // 保留及广播
+	netip.MustParsePrefix("::/128"),         // 未指定
+	netip.MustParsePrefix("::1/128"),        // 回环
+	netip.MustParsePrefix("fc00::/7"),       // ULA
+	netip.MustParsePrefix("fe80::/10"),      // 链路本地
+	netip.MustParsePrefix("ff00::/8"),       // 组播
+}
+
+// nat64Prefix NAT64 地址内嵌 IPv4 地址，按内嵌的地址判断
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// isPrivateDestination 是否为默认禁止访问的地址，无效地址视为禁止
+func isPrivateDestination(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return true
+	}
+	ip = ip.Unmap()
+	if nat64Prefix.Contains(ip) {
+		b := ip.As16()
+		ip = netip.AddrFrom4([4]byte(b[12:]))
+	}
+	for _, prefix := range privateNetworks {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDestination 按实际连接的 IP 检查目标：未设置 AllowPrivateDestinations 时先拦截内网地址，再判断访问控制规则
+// 在解析域名之后调用，域名解析到内网地址或 DNS rebinding 同样会被拦截
+func (s5 *Socks5Server) checkDestination(sess *session, target aclTarget) error {
+	if !s5.Config.AllowPrivateDestinations && isPrivateDestination(target.ip) {
+		args := []any{"domain", target.domain, "ip", target.ip, "port", target.port, "command", target.command}
+		if sess != nil {
+			args = append(args, "user", sess.userName(), "remoteAddr", sess.clientAddr)
+		}
+		slog.Warn("private destination blocked", args...)
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, target.ip)
+	}
+	return s5.checkAccess(sess, target)
+}
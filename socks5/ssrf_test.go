@@ -0,0 +1,121 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsPrivateDestination(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"0.0.0.0", true},
+		{"8.8.8.8", false},
+		{"::1", true},
+		{"::", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:8.8.8.8", false},
+		{"64:ff9b::a00:1", true},
+		{"64:ff9b::808:808", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		if got := isPrivateDestination(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Fatalf("want get %v for %s but got %v", tt.want, tt.ip, got)
+		}
+	}
+}
+
+func TestSocks5Server_PrivateDestinations(t *testing.T) {
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{Method: MethodNoAuth, Timeout: time.Second}}
+	proxyAddr := serveForTest(t, s)
+
+	for _, address := range []string{
+		target.String(),
+		// 域名解析到回环地址同样拦截
+		fmt.Sprintf("localhost:%d", target.Port),
+		fmt.Sprintf("[::1]:%d", target.Port),
+	} {
+		t.Run(address, func(t *testing.T) {
+			dialer := &Dialer{ProxyAddr: proxyAddr, Timeout: 5 * time.Second}
+			conn, err := dialer.Dial("tcp", address)
+			var replyError *ReplyError
+			if !errors.As(err, &replyError) || replyError.Reply != ReplyRegularDenied {
+				if conn != nil {
+					conn.Close()
+				}
+				t.Fatalf("want get ReplyRegularDenied but got %v", err)
+			}
+		})
+	}
+
+	t.Run("http forward", func(t *testing.T) {
+		httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer httpServer.Close()
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}}
+		resp, err := client.Get(httpServer.URL)
+		if err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("want get 403 but got   %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("udp datagram dropped", func(t *testing.T) {
+		echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.ListenPacket error %s", err)
+		}
+		defer echo.Close()
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatalf("net.Dial error %s", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte{Socks5, 1, MethodNoAuth, Socks5, CommandUdpAssociate, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0})
+		buff := make([]byte, 2+10)
+		if _, err := io.ReadFull(conn, buff); err != nil || buff[3] != ReplySuccess {
+			t.Fatalf("udp associate failed %v %s", buff, err)
+		}
+		_, relayAddress, _, err := parseAddress(buff[5:])
+		if err != nil {
+			t.Fatalf("parseAddress error %s", err)
+		}
+		udpConn, err := net.Dial("udp", relayAddress)
+		if err != nil {
+			t.Fatalf("net.Dial udp error %s", err)
+		}
+		defer udpConn.Close()
+		packet, _ := (&UdpMessage{Address: echo.LocalAddr().String(), Data: []byte("ping")}).Bytes()
+		udpConn.Write(packet)
+		echo.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		if _, _, err := echo.ReadFrom(make([]byte, 1024)); err == nil {
+			t.Fatalf("want get datagram dropped but target received it")
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package socks5
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrLockedOut 来源 IP 或用户名连续认证失败次数过多，暂时锁定
+var ErrLockedOut = fmt.Errorf("%w: locked out", ErrAuthFailed)
+
+// pruneThreshold 记录数超过该值时清理已过期的记录
+const pruneThreshold = 4096
+
+// AuthThrottle 用户名密码认证的暴力破解防护，按来源 IP 和用户名分别统计连续失败次数
+// 第 n 次失败后，下次认证前延迟 BaseDelay*2^(n-1)（不超过 MaxDelay），连续失败 MaxFailures 次后锁定 LockoutDuration
+// 零值字段使用默认值，可直接使用 &AuthThrottle{}
+type AuthThrottle struct {
+	// MaxFailures 连续失败达到该次数后锁定，默认 5
+	MaxFailures int
+	// BaseDelay 失败后的延迟基数，默认 500ms
+	BaseDelay time.Duration
+	// MaxDelay 延迟上限，默认 5s
+	MaxDelay time.Duration
+	// LockoutDuration 锁定时长，默认 15 分钟
+	LockoutDuration time.Duration
+	// FailureWindow 距上次失败超过该时长后失败次数清零，默认 15 分钟
+	FailureWindow time.Duration
+	// MaxEntries 最多保留的记录数，超过后淘汰最早失败的记录（优先淘汰未锁定的），默认 65536
+	MaxEntries int
+
+	// now 当前时间，测试时替换为假时钟
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[throttleKey]*throttleEntry
+}
+
+type throttleKey struct {
+	// kind ip 或 user
+	kind  string
+	value string
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Lockout 锁定记录，用于管理接口展示
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+func (t *AuthThrottle) maxFailures() int {
+	if t.MaxFailures > 0 {
+		return t.MaxFailures
+	}
+	return 5
+}
+
+func (t *AuthThrottle) baseDelay() time.Duration {
+	if t.BaseDelay > 0 {
+		return t.BaseDelay
+	}
+	return 500 * time.Millisecond
+}
+
+func (t *AuthThrottle) maxDelay() time.Duration {
+	if t.MaxDelay > 0 {
+		return t.MaxDelay
+	}
+	return 5 * time.Second
+}
+
+func (t *AuthThrottle) lockoutDuration() time.Duration {
+	if t.LockoutDuration > 0 {
+		return t.LockoutDuration
+	}
+	return 15 * time.Minute
+}
+
+func (t *AuthThrottle) failureWindow() time.Duration {
+	if t.FailureWindow > 0 {
+		return t.FailureWindow
+	}
+	return 15 * time.Minute
+}
+
+func (t *AuthThrottle) maxEntries() int {
+	if t.MaxEntries > 0 {
+		return t.MaxEntries
+	}
+	return 65536
+}
+
+func (t *AuthThrottle) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func throttleKeys(ip, userName string) []throttleKey {
+	keys := make([]throttleKey, 0, 2)
+	if ip != "" {
+		keys = append(keys, throttleKey{kind: "ip", value: ip})
+	}
+	if userName != "" {
+		keys = append(keys, throttleKey{kind: "user", value: userName})
+	}
+	return keys
+}
+
+// entry 返回未过期的记录，已过期的删除，调用方需持有锁
+func (t *AuthThrottle) entry(key throttleKey, now time.Time) *throttleEntry {
+	entry, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if t.expired(entry, now) {
+		delete(t.entries, key)
+		return nil
+	}
+	return entry
+}
+
+func (t *AuthThrottle) expired(entry *throttleEntry, now time.Time) bool {
+	if !entry.lockedUntil.IsZero() {
+		return !now.Before(entry.lockedUntil)
+	}
+	return now.Sub(entry.lastFailure) > t.failureWindow()
+}
+
+// Allow 认证前调用：来源 IP 或用户名已锁定时返回 ErrLockedOut，否则返回认证前需要等待的时长
+func (t *AuthThrottle) Allow(ip, userName string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock()
+	failures := 0
+	for _, key := range throttleKeys(ip, userName) {
+		entry := t.entry(key, now)
+		if entry == nil {
+			continue
+		}
+		if !entry.lockedUntil.IsZero() {
+			return 0, ErrLockedOut
+		}
+		failures = max(failures, entry.failures)
+	}
+	if failures == 0 {
+		return 0, nil
+	}
+	delay := t.baseDelay() << (failures - 1)
+	if delay <= 0 || delay > t.maxDelay() {
+		delay = t.maxDelay()
+	}
+	return delay, nil
+}
+
+// Failure 记录一次认证失败，达到阈值时锁定
+func (t *AuthThrottle) Failure(ip, userName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock()
+	if t.entries == nil {
+		t.entries = map[throttleKey]*throttleEntry{}
+	}
+	if len(t.entries) > pruneThreshold {
+		t.prune(now)
+	}
+	for _, key := range throttleKeys(ip, userName) {
+		entry := t.entry(key, now)
+		if entry == nil {
+			if len(t.entries) >= t.maxEntries() {
+				t.evict(now)
+			}
+			entry = &throttleEntry{}
+			t.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.lockedUntil.IsZero() && entry.failures >= t.maxFailures() {
+			entry.lockedUntil = now.Add(t.lockoutDuration())
+			slog.Warn("auth lockout", "kind", key.kind, "key", key.value, "failures", entry.failures, "lockedUntil", entry.lockedUntil)
+		}
+	}
+}
+
+// Success 认证成功后清零该用户名的失败次数
+// 来源 IP 的失败次数不清零，避免攻击者用一个有效账号重置对其他账号的猜测计数
+func (t *AuthThrottle) Success(userName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, throttleKey{kind: "user", value: userName})
+}
+
+// Lockouts 当前处于锁定状态的来源 IP 和用户名，按解锁时间排序
+func (t *AuthThrottle) Lockouts() []Lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock()
+	t.prune(now)
+	lockouts := []Lockout{}
+	for key, entry := range t.entries {
+		if entry.lockedUntil.IsZero() {
+			continue
+		}
+		lockouts = append(lockouts, Lockout{Kind: key.kind, Key: key.value, Failures: entry.failures, LockedUntil: entry.lockedUntil})
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LockedUntil.Before(lockouts[j].LockedUntil)
+	})
+	return lockouts
+}
+
+// prune 删除已过期的记录，调用方需持有锁
+func (t *AuthThrottle) prune(now time.Time) {
+	for key, entry := range t.entries {
+		if t.expired(entry, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// evict 记录数达到上限时调用，先删除已过期的记录，仍不足时淘汰最早失败的记录
+// 一次淘汰到上限的 7/8，避免来源 IP 不断变化时每次失败都排序，调用方需持有锁
+func (t *AuthThrottle) evict(now time.Time) {
+	t.prune(now)
+	limit := t.maxEntries()
+	if len(t.entries) < limit {
+		return
+	}
+	keys := make([]throttleKey, 0, len(t.entries))
+	for key := range t.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := t.entries[keys[i]], t.entries[keys[j]]
+		if a.lockedUntil.IsZero() != b.lockedUntil.IsZero() {
+			return a.lockedUntil.IsZero()
+		}
+		return a.lastFailure.Before(b.lastFailure)
+	})
+	evicted := keys[:len(keys)-limit*7/8]
+	for _, key := range evicted {
+		delete(t.entries, key)
+	}
+	slog.Warn("auth throttle table full, evict oldest entries", "evicted", len(evicted), "maxEntries", limit)
+}
@@ -0,0 +1,151 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock 测试用的假时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func throttleForTest() (*AuthThrottle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return &AuthThrottle{
+		MaxFailures:     4,
+		BaseDelay:       time.Second,
+		MaxDelay:        3 * time.Second,
+		LockoutDuration: time.Minute,
+		FailureWindow:   10 * time.Minute,
+		now:             clock.Now,
+	}, clock
+}
+
+func TestAuthThrottle_DelayAndLockout(t *testing.T) {
+	throttle, clock := throttleForTest()
+	wantDelays := []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}
+	for i, want := range wantDelays {
+		delay, err := throttle.Allow("203.0.113.1", "admin")
+		if err != nil || delay != want {
+			t.Fatalf("want get delay %s after %d failures but got %s %v", want, i, delay, err)
+		}
+		throttle.Failure("203.0.113.1", "admin")
+	}
+	if _, err := throttle.Allow("203.0.113.1", "other"); !errors.Is(err, ErrLockedOut) || !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("want get ErrLockedOut for locked ip but got %v", err)
+	}
+	if _, err := throttle.Allow("198.51.100.1", "admin"); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want get ErrLockedOut for locked user but got %v", err)
+	}
+	if lockouts := throttle.Lockouts(); len(lockouts) != 2 {
+		t.Fatalf("want get 2 lockouts but got %v", lockouts)
+	}
+
+	clock.Advance(time.Minute)
+	if delay, err := throttle.Allow("203.0.113.1", "admin"); err != nil || delay != 0 {
+		t.Fatalf("want get unlocked after lockout duration but got %s %v", delay, err)
+	}
+	if lockouts := throttle.Lockouts(); len(lockouts) != 0 {
+		t.Fatalf("want get no lockouts but got %v", lockouts)
+	}
+}
+
+func TestAuthThrottle_PerUsername(t *testing.T) {
+	throttle, _ := throttleForTest()
+	// 撞库时每次换一个来源 IP，仍按用户名锁定
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4"} {
+		throttle.Failure(ip, "admin")
+	}
+	if _, err := throttle.Allow("203.0.113.5", "admin"); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want get ErrLockedOut but got %v", err)
+	}
+	if delay, err := throttle.Allow("203.0.113.5", "alice"); err != nil || delay != 0 {
+		t.Fatalf("want get other user allowed but got %s %v", delay, err)
+	}
+}
+
+func TestAuthThrottle_SuccessAndWindow(t *testing.T) {
+	throttle, clock := throttleForTest()
+	throttle.Failure("203.0.113.1", "admin")
+	throttle.Failure("203.0.113.1", "admin")
+	throttle.Success("admin")
+	// 成功只清零用户名，来源 IP 的失败次数保留
+	if delay, _ := throttle.Allow("", "admin"); delay != 0 {
+		t.Fatalf("want get user delay 0 after success but got %s", delay)
+	}
+	if delay, _ := throttle.Allow("203.0.113.1", ""); delay != 2*time.Second {
+		t.Fatalf("want get ip delay 2s but got %s", delay)
+	}
+	clock.Advance(10*time.Minute + time.Second)
+	if delay, _ := throttle.Allow("203.0.113.1", ""); delay != 0 {
+		t.Fatalf("want get delay 0 after failure window but got %s", delay)
+	}
+}
+
+func TestAuthThrottle_MaxEntries(t *testing.T) {
+	throttle, clock := throttleForTest()
+	throttle.MaxEntries = 8
+	for i := 0; i < 4; i++ {
+		throttle.Failure("203.0.113.1", "")
+	}
+	// 不断变化的来源 IP 填满记录表，记录数不超过上限，淘汰最早失败且未锁定的记录
+	for i := 0; i < 20; i++ {
+		clock.Advance(time.Second)
+		throttle.Failure(fmt.Sprintf("198.51.100.%d", i), "")
+		if len(throttle.entries) > throttle.MaxEntries {
+			t.Fatalf("want get at most %d entries but got %d", throttle.MaxEntries, len(throttle.entries))
+		}
+	}
+	if _, err := throttle.Allow("203.0.113.1", ""); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want get ErrLockedOut but got %v", err)
+	}
+	if delay, _ := throttle.Allow("198.51.100.0", ""); delay != 0 {
+		t.Fatalf("want get oldest entry evicted but got delay %s", delay)
+	}
+	if delay, _ := throttle.Allow("198.51.100.19", ""); delay != time.Second {
+		t.Fatalf("want get newest entry delay 1s but got %s", delay)
+	}
+}
+
+func TestUserPasswdAuthenticator_Throttle(t *testing.T) {
+	throttle, _ := throttleForTest()
+	throttle.BaseDelay = time.Nanosecond
+	a := &UserPasswdAuthenticator{
+		CheckAuthFunc: func(userName, passwd string) bool { return userName == "admin" && passwd == "123456" },
+		Throttle:      throttle,
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 1234}
+	for i := 0; i < 4; i++ {
+		if err := a.CheckPasswd(addr, "admin", "wrong"); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("want get ErrAuthFailed but got %v", err)
+		}
+	}
+	// 锁定期间正确的密码也被拒绝
+	if err := a.CheckPasswd(addr, "admin", "123456"); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want get ErrLockedOut but got %v", err)
+	}
+
+	s := &Socks5Server{Config: Config{AuthThrottle: throttle, AdminToken: "secret"}}
+	_, body := adminForTest(t, s.AdminHandler(), http.MethodGet, "/lockouts", "secret")
+	var lockouts []Lockout
+	if err := json.Unmarshal([]byte(body), &lockouts); err != nil {
+		t.Fatalf("want get lockouts json but got %s %s", body, err)
+	}
+	if len(lockouts) != 2 || lockouts[0].Failures != 4 {
+		t.Fatalf("want get 2 lockouts but got %v", lockouts)
+	}
+}
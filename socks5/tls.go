@@ -0,0 +1,125 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSServerConfig 服务端 TLS 配置，本地客户端与远程服务端之间的流量加密
+type TLSServerConfig struct {
+	// CertFile KeyFile PEM 格式的服务端证书和私钥
+	CertFile string
+	KeyFile  string
+	// ClientCAFile 不为空时要求客户端出示由该 CA 签发的证书，证书的 CN/SAN 作为客户端身份，可替代用户名密码认证
+	ClientCAFile string
+	// ClientCertOptional 客户端可以不出示证书（改用用户名密码认证），出示时仍然校验
+	ClientCertOptional bool
+}
+
+// TLSClientConfig 本地客户端连接远程服务端的 TLS 配置
+type TLSClientConfig struct {
+	// CAFile 只信任该 CA 签发的服务端证书，为空时使用系统根证书
+	CAFile string
+	// ServerName SNI 以及校验证书时使用的名称，为空时取远程服务端地址中的 host
+	ServerName string
+	// InsecureSkipVerify 不校验服务端证书，仅用于测试
+	InsecureSkipVerify bool
+	// CertFile KeyFile 客户端证书和私钥，服务端据此认证本地客户端，无需用户名密码
+	CertFile string
+	KeyFile  string
+}
+
+// NewTLSConfig 根据配置加载证书，生成服务端的 tls.Config
+func (c *TLSServerConfig) NewTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server cert failed: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if c.ClientCertOptional {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+// NewTLSConfig 根据配置生成连接 remoteAddr 的 tls.Config
+func (c *TLSClientConfig) NewTLSConfig(remoteAddr string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert failed: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// tlsClientIdentity 返回已校验的客户端证书的身份：CN，CN 为空时依次取 DNS、Email、URI SAN
+func tlsClientIdentity(conn *tls.Conn) string {
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return certIdentity(state.VerifiedChains[0][0])
+}
+
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// loadCertPool 从 PEM 文件加载 CA 证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("load ca failed: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("load ca failed: no certificate found in " + caFile)
+	}
+	return pool, nil
+}
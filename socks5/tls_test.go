@@ -0,0 +1,204 @@
+package socks5
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCerts 测试时生成的自签名证书文件
+type testCerts struct {
+	caFile, serverCertFile, serverKeyFile, clientCertFile, clientKeyFile string
+	// otherCAFile 与上面无关的另一个 CA
+	otherCAFile string
+}
+
+// certsForTest 生成 CA、服务端证书（127.0.0.1, proxy.test）和客户端证书（CN=relay-1）
+func certsForTest(t *testing.T) *testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	writePEM := func(name, typ string, der []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatalf("write %s error %s", name, err)
+		}
+		return file
+	}
+	newCA := func(name string) (*x509.Certificate, *ecdsa.PrivateKey, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("create ca error %s", err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert, key, writePEM(name+".pem", "CERTIFICATE", der)
+	}
+	newCert := func(name string, template *x509.Certificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (string, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("create cert error %s", err)
+		}
+		keyDer, _ := x509.MarshalECPrivateKey(key)
+		return writePEM(name+".pem", "CERTIFICATE", der), writePEM(name+"-key.pem", "EC PRIVATE KEY", keyDer)
+	}
+
+	certs := &testCerts{}
+	ca, caKey, caFile := newCA("test-ca")
+	certs.caFile = caFile
+	_, _, certs.otherCAFile = newCA("other-ca")
+	certs.serverCertFile, certs.serverKeyFile = newCert("server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy.test"},
+		DNSNames:    []string{"proxy.test"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	certs.clientCertFile, certs.clientKeyFile = newCert("client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "relay-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	return certs
+}
+
+// connectViaClientForTest 通过本地客户端 CONNECT 回显服务并校验数据
+func connectViaClientForTest(address string, target *net.TCPAddr) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := []byte{Socks5, 1, MethodNoAuth, Socks5, CommandConnect, RSV, AddressTypeIPv4}
+	request = append(request, target.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	conn.Write(append(request, "ping"...))
+	buff := make([]byte, 2+10+4)
+	if _, err := io.ReadFull(conn, buff); err != nil {
+		return err
+	}
+	if buff[3] != ReplySuccess || string(buff[12:]) != "ping" {
+		return &ReplyError{Reply: buff[3]}
+	}
+	return nil
+}
+
+func TestTLS(t *testing.T) {
+	certs := certsForTest(t)
+	target := echoForTest(t)
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second},
+		TLS: &TLSServerConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile}}
+	remoteAddr := serveForTest(t, s)
+
+	tests := []struct {
+		name    string
+		tls     *TLSClientConfig
+		wantErr bool
+	}{
+		{"ca pinned", &TLSClientConfig{CAFile: certs.caFile}, false},
+		{"ca pinned with sni", &TLSClientConfig{CAFile: certs.caFile, ServerName: "proxy.test"}, false},
+		{"insecure skip verify", &TLSClientConfig{InsecureSkipVerify: true}, false},
+		{"other ca", &TLSClientConfig{CAFile: certs.otherCAFile}, true},
+		{"wrong server name", &TLSClientConfig{CAFile: certs.caFile, ServerName: "other.test"}, true},
+		{"plain tcp", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{RemoteAddr: remoteAddr, TLS: tt.tls}
+			err := connectViaClientForTest(serveForTest(t, c), target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want get err %v but got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("client cert required", func(t *testing.T) {
+		s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second},
+			TLS: &TLSServerConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile, ClientCAFile: certs.caFile}}
+		c := &Client{RemoteAddr: serveForTest(t, s), TLS: &TLSClientConfig{CAFile: certs.caFile}}
+		if err := connectViaClientForTest(serveForTest(t, c), target); err == nil {
+			t.Fatalf("want get err but got nil")
+		}
+	})
+}
+
+func TestTLS_ClientCertAuth(t *testing.T) {
+	certs := certsForTest(t)
+	target := echoForTest(t)
+
+	newServer := func(checkCert func(identity string) bool) *Socks5Server {
+		return &Socks5Server{IsServer: true,
+			Config: Config{
+				AllowPrivateDestinations: true,
+				Method:                   MethodUserPasswd,
+				Timeout:                  time.Second,
+				CheckAuthFunc: func(userName, password string) bool {
+					return userName == "admin" && password == "123456"
+				},
+				CheckCertFunc: checkCert,
+			},
+			TLS: &TLSServerConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile,
+				ClientCAFile: certs.caFile, ClientCertOptional: true},
+		}
+	}
+	clientTLS := &TLSClientConfig{CAFile: certs.caFile, CertFile: certs.clientCertFile, KeyFile: certs.clientKeyFile}
+
+	t.Run("client cert without passwd should success", func(t *testing.T) {
+		var identity string
+		s := newServer(func(id string) bool {
+			identity = id
+			return true
+		})
+		c := &Client{RemoteAddr: serveForTest(t, s), TLS: clientTLS}
+		if err := connectViaClientForTest(serveForTest(t, c), target); err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		if identity != "relay-1" {
+			t.Fatalf("want get identity relay-1 but got %q", identity)
+		}
+	})
+
+	t.Run("client cert rejected by CheckCertFunc should fail", func(t *testing.T) {
+		s := newServer(func(id string) bool { return false })
+		c := &Client{RemoteAddr: serveForTest(t, s), TLS: clientTLS}
+		if err := connectViaClientForTest(serveForTest(t, c), target); err == nil {
+			t.Fatalf("want get err but got nil")
+		}
+	})
+
+	t.Run("no client cert falls back to passwd", func(t *testing.T) {
+		s := newServer(nil)
+		remoteAddr := serveForTest(t, s)
+		c := &Client{RemoteAddr: remoteAddr, TLS: &TLSClientConfig{CAFile: certs.caFile}, Username: "admin", Passwd: "123456"}
+		if err := connectViaClientForTest(serveForTest(t, c), target); err != nil {
+			t.Fatalf("want get err == nil but got err  %s", err)
+		}
+		c = &Client{RemoteAddr: remoteAddr, TLS: &TLSClientConfig{CAFile: certs.caFile}}
+		if err := connectViaClientForTest(serveForTest(t, c), target); err == nil {
+			t.Fatalf("want get err but got nil")
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// udp 数据报的最大长度
+const maxUdpPacketSize = 64 * 1024
+
+// NewUdpMessage 解析客户端发来的 UDP 数据报
+func NewUdpMessage(buff []byte) (*UdpMessage, error) {
+	if len(buff) < 3 {
+		return nil, errors.New("NewUdpMessage short packet")
+	}
+	addressType, address, n, err := parseAddress(buff[3:])
+	if err != nil {
+		return nil, err
+	}
+	udpMessage := UdpMessage{
+		Rsv:         binary.BigEndian.Uint16(buff[0:2]),
+		Frag:        buff[2],
+		AddressType: addressType,
+		Address:     address,
+		Data:        buff[3+n:],
+	}
+	return &udpMessage, nil
+}
+
+// Bytes 编码 UDP 数据报，地址类型由 Address 决定
+func (m *UdpMessage) Bytes() ([]byte, error) {
+	host, port, err := splitHostPort(m.Address)
+	if err != nil {
+		return nil, err
+	}
+	buff := make([]byte, 0, 3+1+net.IPv6len+2+len(m.Data))
+	buff = binary.BigEndian.AppendUint16(buff, m.Rsv)
+	buff = append(buff, m.Frag)
+	buff, err = appendAddress(buff, host, port)
+	if err != nil {
+		return nil, err
+	}
+	return append(buff, m.Data...), nil
+}
+
+// handleUdp UDP ASSOCIATE: 绑定 UDP 端口并在客户端和目标之间双向转发数据报，控制连接关闭时中继随之关闭
+func (s5 *Socks5Server) handleUdp(conn net.Conn, sess *session, message *RequestMessage) error {
+	if err := s5.checkQuota(sess); err != nil {
+		NewRequestReplyFailMessage(conn, ReplyRegularDenied)
+		return err
+	}
+	var bindIP net.IP
+	if localAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		bindIP = localAddr.IP
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindIP})
+	if err != nil {
+		slog.Error("net.ListenUDP error", "err", err)
+		NewRequestReplyFailMessage(conn, ReplyCommonFail)
+		return err
+	}
+	defer udpConn.Close()
+	if _, err := NewReplyMessage(ReplySuccess, udpConn.LocalAddr()).WriteTo(conn); err != nil {
+		return err
+	}
+	sess.setEstablished()
+	slog.Debug("udp associate", "bindAddr", udpConn.LocalAddr(), "clientAddr", conn.RemoteAddr(), "dstAddr", message.Address)
+
+	// 超出配额断开连接时关闭 UDP 中继
+	if accounting := s5.Config.Accounting; accounting != nil && sess != nil && sess.user != "" {
+		defer accounting.track(sess.user, func() {
+			sess.setReason("quota exceeded")
+			udpConn.Close()
+		})()
+	}
+	// 控制连接关闭（客户端断开或出错）时，关闭 UDP 中继
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		sess.setReason("client closed")
+		udpConn.Close()
+	}()
+
+	// 只接受来自控制连接同一主机的数据报；请求中给出了源端口时同时校验端口
+	var clientIP net.IP
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = tcpAddr.IP
+	}
+	var clientAddr *net.UDPAddr
+	if _, port, err := splitHostPort(message.Address); err == nil && port != 0 {
+		clientAddr = &net.UDPAddr{IP: clientIP, Port: int(port)}
+	}
+	return s5.relayUdp(udpConn, sess, clientIP, clientAddr)
+}
+
+// relayUdp 转发数据报，来自客户端的解包发往目标，来自目标的加上头部发回客户端
+func (s5 *Socks5Server) relayUdp(udpConn *net.UDPConn, sess *session, clientIP net.IP, clientAddr *net.UDPAddr) error {
+	buff := make([]byte, maxUdpPacketSize)
+	for {
+		n, srcAddr, err := udpConn.ReadFromUDP(buff)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fromClient := false
+		if clientAddr == nil {
+			// 第一个来自客户端主机的数据报确定客户端的 UDP 地址
+			if clientIP == nil || clientIP.Equal(srcAddr.IP) {
+				clientAddr = srcAddr
+				fromClient = true
+			}
+		} else {
+			fromClient = clientAddr.IP.Equal(srcAddr.IP) && clientAddr.Port == srcAddr.Port
+		}
+
+		if fromClient {
+			udpMessage, err := NewUdpMessage(buff[:n])
+			if err != nil {
+				slog.Debug("NewUdpMessage error", "srcAddr", srcAddr, "err", err)
+				continue
+			}
+			// 不支持分片，直接丢弃
+			if udpMessage.Frag != 0 {
+				continue
+			}
+			dstAddr, err := net.ResolveUDPAddr("udp", udpMessage.Address)
+			if err != nil {
+				slog.Debug("net.ResolveUDPAddr error", "dstAddr", udpMessage.Address, "err", err)
+				continue
+			}
+			// 按解析后的 IP 拦截内网地址并判断访问控制，拒绝的数据报直接丢弃
+			target, err := newACLTarget(CommandUdpAssociate, udpMessage.Address)
+			if err != nil {
+				continue
+			}
+			target.ip = dstAddr.AddrPort().Addr().Unmap()
+			if s5.checkDestination(sess, target) != nil {
+				continue
+			}
+			sess.setTarget(udpMessage.Address)
+			sess.setResolvedIP(target.ip.String())
+			if _, err := udpConn.WriteToUDP(udpMessage.Data, dstAddr); err != nil {
+				slog.Debug("udp write to target error", "dstAddr", dstAddr, "err", err)
+				continue
+			}
+			s5.countUdp(sess, Usage{Upload: int64(len(udpMessage.Data))})
+			continue
+		}
+
+		if clientAddr == nil {
+			continue
+		}
+		udpMessage := UdpMessage{Address: srcAddr.String(), Data: buff[:n]}
+		packet, err := udpMessage.Bytes()
+		if err != nil {
+			continue
+		}
+		if _, err := udpConn.WriteToUDP(packet, clientAddr); err != nil {
+			slog.Debug("udp write to client error", "clientAddr", clientAddr, "err", err)
+			continue
+		}
+		s5.countUdp(sess, Usage{Download: int64(n)})
+	}
+}
+
+// countUdp 统计 UDP 转发的数据（不含 SOCKS5 UDP 头部）
+func (s5 *Socks5Server) countUdp(sess *session, usage Usage) {
+	if sess != nil {
+		sess.upload.Add(usage.Upload)
+		sess.download.Add(usage.Download)
+	}
+	if accounting := s5.Config.Accounting; accounting != nil && sess != nil && sess.user != "" {
+		accounting.count(sess.user, usage)
+	}
+}
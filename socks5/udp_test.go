@@ -0,0 +1,103 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUdpMessage(t *testing.T) {
+	t.Run("test UdpMessage encode and decode", func(t *testing.T) {
+		for _, address := range []string{"1.2.3.4:53", "[2001:db8::1]:443", "example.com:65535"} {
+			udpMessage := UdpMessage{Address: address, Data: []byte("hello")}
+			packet, err := udpMessage.Bytes()
+			if err != nil {
+				t.Fatalf("want get err == nil but got err  %s", err)
+			}
+			message, err := NewUdpMessage(packet)
+			if err != nil {
+				t.Fatalf("want get err == nil but got err  %s", err)
+			}
+			if message.Address != address || !bytes.Equal(message.Data, udpMessage.Data) {
+				t.Fatalf("want get %v but got   %v", udpMessage, message)
+			}
+		}
+	})
+
+	t.Run("test NewUdpMessage short packet should fail", func(t *testing.T) {
+		_, err := NewUdpMessage([]byte{0, 0, 0, byte(AddressTypeIPv4), 1, 2})
+		if err == nil {
+			t.Fatalf("want get err but got nil")
+		}
+	})
+}
+
+func TestSocks5Server_UdpAssociate(t *testing.T) {
+	// 目标：UDP 回显服务
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket error %s", err)
+	}
+	defer echo.Close()
+	go func() {
+		buff := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buff[:n], addr)
+		}
+	}()
+
+	s := &Socks5Server{IsServer: true, Config: Config{AllowPrivateDestinations: true, Method: MethodNoAuth, Timeout: time.Second}}
+	conn, err := net.Dial("tcp", serveForTest(t, s))
+	if err != nil {
+		t.Fatalf("net.Dial error %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{Socks5, 1, MethodNoAuth})
+	buff := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != MethodNoAuth {
+		t.Fatalf("auth failed %v %s", buff, err)
+	}
+	conn.Write([]byte{Socks5, CommandUdpAssociate, RSV, AddressTypeIPv4, 0, 0, 0, 0, 0, 0})
+	buff = make([]byte, 10)
+	if _, err := io.ReadFull(conn, buff); err != nil || buff[1] != ReplySuccess || buff[3] != AddressTypeIPv4 {
+		t.Fatalf("udp associate failed %v %s", buff, err)
+	}
+	_, relayAddress, _, err := parseAddress(buff[3:])
+	if err != nil {
+		t.Fatalf("parseAddress error %s", err)
+	}
+
+	udpConn, err := net.Dial("udp", relayAddress)
+	if err != nil {
+		t.Fatalf("net.Dial udp error %s", err)
+	}
+	defer udpConn.Close()
+	udpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := UdpMessage{Address: echo.LocalAddr().String(), Data: []byte("ping")}
+	packet, _ := request.Bytes()
+	if _, err := udpConn.Write(packet); err != nil {
+		t.Fatalf("udp write error %s", err)
+	}
+	buff = make([]byte, 1024)
+	n, err := udpConn.Read(buff)
+	if err != nil {
+		t.Fatalf("udp read error %s", err)
+	}
+	reply, err := NewUdpMessage(buff[:n])
+	if err != nil {
+		t.Fatalf("NewUdpMessage error %s", err)
+	}
+	want := UdpMessage{AddressType: AddressTypeIPv4, Address: echo.LocalAddr().String(), Data: []byte("ping")}
+	if !reflect.DeepEqual(*reply, want) {
+		t.Fatalf("want get %v but got   %v", want, *reply)
+	}
+}
@@ -0,0 +1,298 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash 用户不存在时也做一次 bcrypt 比较，避免通过响应时间判断用户是否存在
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy passwd"), bcrypt.DefaultCost)
+
+// UserDB 基于 htpasswd 格式文件的用户库，每行 user:hash
+// 支持 bcrypt（$2y$ $2a$ $2b$，htpasswd -B）、argon2id（$argon2id$），以及用于导入的 {SHA}（htpasswd -s）和 {SSHA}
+type UserDB struct {
+	file string
+
+	mu      sync.RWMutex
+	users   map[string]string
+	modTime time.Time
+}
+
+// NewUserDB 加载用户文件，文件不存在时为空的用户库（之后 Save 会创建）
+func NewUserDB(file string) (*UserDB, error) {
+	db := &UserDB{file: file, users: map[string]string{}}
+	if err := db.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load 重新加载用户文件
+func (db *UserDB) Load() error {
+	info, err := os.Stat(db.file)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(db.file)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(content)
+	if err != nil {
+		return fmt.Errorf("load %s failed: %w", db.file, err)
+	}
+	db.mu.Lock()
+	db.users = users
+	db.modTime = info.ModTime()
+	db.mu.Unlock()
+	return nil
+}
+
+// reloadIfChanged 文件修改时间变化时重新加载
+func (db *UserDB) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(db.file)
+	if err != nil {
+		return false, err
+	}
+	db.mu.RLock()
+	changed := !info.ModTime().Equal(db.modTime)
+	db.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, db.Load()
+}
+
+// Watch 每隔 interval 检查一次文件，修改后自动重新加载，直到 ctx 结束
+func (db *UserDB) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := db.reloadIfChanged()
+			if err != nil {
+				slog.Error("reload user file failed", "file", db.file, "err", err)
+			} else if reloaded {
+				slog.Info("user file reloaded", "file", db.file, "users", len(db.List()))
+			}
+		}
+	}
+}
+
+// Check 校验用户名密码，可直接作为 CheckAuthFunc
+func (db *UserDB) Check(userName, passwd string) bool {
+	db.mu.RLock()
+	hash, ok := db.users[userName]
+	db.mu.RUnlock()
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(passwd))
+		return false
+	}
+	return checkPasswdHash(hash, passwd)
+}
+
+// Add 添加或修改用户，密码使用 bcrypt 保存
+func (db *UserDB) Add(userName, passwd string) error {
+	if userName == "" || strings.ContainsAny(userName, ":\r\n") || len(userName) > 255 {
+		return fmt.Errorf("invalid user name %q", userName)
+	}
+	if len(passwd) > 72 {
+		return errors.New("passwd longer than 72 bytes is not supported by bcrypt")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.users[userName] = string(hash)
+	db.mu.Unlock()
+	return nil
+}
+
+// Delete 删除用户，返回用户是否存在
+func (db *UserDB) Delete(userName string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, ok := db.users[userName]
+	delete(db.users, userName)
+	return ok
+}
+
+// List 按名称排序的用户列表
+func (db *UserDB) List() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.userNames()
+}
+
+// Save 写回用户文件，先写临时文件再改名，避免服务端读到写了一半的文件
+func (db *UserDB) Save() error {
+	var buff bytes.Buffer
+	db.mu.RLock()
+	for _, name := range db.userNames() {
+		fmt.Fprintf(&buff, "%s:%s\n", name, db.users[name])
+	}
+	db.mu.RUnlock()
+	tmp, err := os.CreateTemp(filepath.Dir(db.file), filepath.Base(db.file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buff.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), db.file)
+}
+
+// userNames 调用方需持有锁
+func (db *UserDB) userNames() []string {
+	names := make([]string, 0, len(db.users))
+	for name := range db.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseHtpasswd 解析 htpasswd 格式，忽略空行和 # 注释
+func parseHtpasswd(content []byte) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" || hash == "" {
+			return nil, fmt.Errorf("line %d: expect user:hash", lineNo)
+		}
+		if !supportedPasswdHash(hash) {
+			return nil, fmt.Errorf("line %d: unsupported hash for user %q", lineNo, name)
+		}
+		// argon2id 的参数在登录时直接传给 argon2.IDKey，非法参数会导致 panic，加载时校验
+		if strings.HasPrefix(hash, "$argon2id$") {
+			if _, err := parseArgon2id(hash); err != nil {
+				return nil, fmt.Errorf("line %d: invalid argon2id hash for user %q: %w", lineNo, name, err)
+			}
+		}
+		users[name] = hash
+	}
+	return users, scanner.Err()
+}
+
+func supportedPasswdHash(hash string) bool {
+	for _, prefix := range []string{"$2y$", "$2a$", "$2b$", "$argon2id$", "{SHA}", "{SSHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPasswdHash 按 hash 的格式校验密码，比较均为常量时间
+func checkPasswdHash(hash, passwd string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return checkArgon2id(hash, passwd)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(passwd))
+		want := []byte(base64.StdEncoding.EncodeToString(sum[:]))
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), want) == 1
+	case strings.HasPrefix(hash, "{SSHA}"):
+		decoded, err := base64.StdEncoding.DecodeString(hash[len("{SSHA}"):])
+		if err != nil || len(decoded) <= sha1.Size {
+			return false
+		}
+		digest, salt := decoded[:sha1.Size], decoded[sha1.Size:]
+		sum := sha1.Sum(append([]byte(passwd), salt...))
+		return subtle.ConstantTimeCompare(digest, sum[:]) == 1
+	}
+	return false
+}
+
+// argon2idMaxMemory argon2id 的 m 上限（KiB），避免用户文件中过大的参数在登录时耗尽内存
+const argon2idMaxMemory = 4 << 20
+
+// argon2idHash 解析后的 argon2id 参数
+type argon2idHash struct {
+	memory  uint32
+	times   uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id 解析并校验 PHC 格式的 argon2id：$argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func parseArgon2id(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("expect $argon2id$v=19$m=...,t=...,p=...$salt$hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported version %q", parts[2])
+	}
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.times, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid parameters %q", parts[3])
+	}
+	if h.times < 1 {
+		return nil, errors.New("t must be at least 1")
+	}
+	if h.threads < 1 {
+		return nil, errors.New("p must be at least 1")
+	}
+	if h.memory < 8*uint32(h.threads) || h.memory > argon2idMaxMemory {
+		return nil, fmt.Errorf("m must be between 8*p and %d", argon2idMaxMemory)
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) < 8 {
+		return nil, errors.New("salt must be at least 8 bytes of base64")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) < 4 {
+		return nil, errors.New("hash must be at least 4 bytes of base64")
+	}
+	return &h, nil
+}
+
+// checkArgon2id 校验 argon2id 格式的密码
+func checkArgon2id(hash, passwd string) bool {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(passwd), h.salt, h.times, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(got, h.key) == 1
+}
@@ -0,0 +1,136 @@
+package socks5
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserDB_Check(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	salt := []byte("saltsalt")
+	argonHash := fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("argon-pass"), salt, 1, 1024, 1, 32)))
+	ssha := sha1.Sum(append([]byte("ssha-pass"), salt...))
+	sshaHash := "{SSHA}" + base64.StdEncoding.EncodeToString(append(ssha[:], salt...))
+
+	file := filepath.Join(t.TempDir(), "users.htpasswd")
+	content := "# comment\n\n" +
+		"bcrypt:" + string(bcryptHash) + "\n" +
+		"argon:" + argonHash + "\n" +
+		// htpasswd -s sha password
+		"sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"ssha:" + sshaHash + "\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("write file error %s", err)
+	}
+	db, err := NewUserDB(file)
+	if err != nil {
+		t.Fatalf("NewUserDB error %s", err)
+	}
+
+	tests := []struct {
+		user, passwd string
+		want         bool
+	}{
+		{"bcrypt", "bcrypt-pass", true},
+		{"bcrypt", "wrong", false},
+		{"argon", "argon-pass", true},
+		{"argon", "wrong", false},
+		{"sha", "password", true},
+		{"sha", "wrong", false},
+		{"ssha", "ssha-pass", true},
+		{"ssha", "wrong", false},
+		{"nobody", "password", false},
+	}
+	for _, tt := range tests {
+		if got := db.Check(tt.user, tt.passwd); got != tt.want {
+			t.Fatalf("want get %v but got %v for %s/%s", tt.want, got, tt.user, tt.passwd)
+		}
+	}
+}
+
+func TestUserDB_AddSaveReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.htpasswd")
+	db, err := NewUserDB(file)
+	if err != nil {
+		t.Fatalf("NewUserDB error %s", err)
+	}
+	if err := db.Add("bad:name", "x"); err == nil {
+		t.Fatalf("want get err for invalid name but got nil")
+	}
+	if err := db.Add("alice", "alice-pass"); err != nil {
+		t.Fatalf("Add error %s", err)
+	}
+	if err := db.Save(); err != nil {
+		t.Fatalf("Save error %s", err)
+	}
+
+	// 另一个进程（user 子命令）修改文件，服务端检测到后重新加载
+	server, err := NewUserDB(file)
+	if err != nil {
+		t.Fatalf("NewUserDB error %s", err)
+	}
+	if !server.Check("alice", "alice-pass") {
+		t.Fatalf("want get alice ok but got fail")
+	}
+	db.Add("bob", "bob-pass")
+	db.Delete("alice")
+	if err := db.Save(); err != nil {
+		t.Fatalf("Save error %s", err)
+	}
+	// 避免文件系统时间精度导致修改时间不变
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+	if reloaded, err := server.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("want get reloaded but got %v %v", reloaded, err)
+	}
+	if server.Check("alice", "alice-pass") || !server.Check("bob", "bob-pass") {
+		t.Fatalf("want get bob only but got %v", server.List())
+	}
+	if reloaded, _ := server.reloadIfChanged(); reloaded {
+		t.Fatalf("want get no reload for unchanged file but got reloaded")
+	}
+}
+
+func TestParseHtpasswd_Invalid(t *testing.T) {
+	for _, content := range []string{"alice", "alice:plaintext", ":{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="} {
+		if _, err := parseHtpasswd([]byte(content)); err == nil {
+			t.Fatalf("want get err for %q but got nil", content)
+		}
+	}
+}
+
+func TestParseHtpasswd_InvalidArgon2id(t *testing.T) {
+	// 盐和哈希均为 "saltsalt"
+	for name, hash := range map[string]string{
+		"t=0":         "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"p=0":         "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"m=0":         "$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"m<8*p":       "$argon2id$v=19$m=15,t=1,p=2$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"m too large": "$argon2id$v=19$m=4194305,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"short salt":  "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$c2FsdHNhbHQ",
+		"short key":   "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$YQ",
+		"empty key":   "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+		"bad version": "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"bad params":  "$argon2id$v=19$m=1024$c2FsdHNhbHQ$c2FsdHNhbHQ",
+	} {
+		content := "bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\nalice:" + hash
+		_, err := parseHtpasswd([]byte(content))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("want get err at line 2 for %s but got %v", name, err)
+		}
+		// 登录时也不会 panic
+		if checkArgon2id(hash, "saltsalt") {
+			t.Fatalf("want get false for %s but got true", name)
+		}
+	}
+}
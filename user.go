@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"socks5server-demo/socks5"
+	"strings"
+)
+
+// userCommand 用户管理子命令：socks5Server user add|del|list [-file=users.htpasswd] [name] [passwd]
+// add 未给出密码时从标准输入读取一行，避免密码出现在 shell 历史中
+func userCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("user", flag.ContinueOnError)
+	fileFlag := flags.String("file", "users.htpasswd", "htpasswd file")
+	flags.SetOutput(stdout)
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "usage: socks5Server user add|del|list [-file=users.htpasswd] [name] [passwd]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing user command")
+	}
+	command := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	db, err := socks5.NewUserDB(*fileFlag)
+	if err != nil {
+		return err
+	}
+	rest := flags.Args()
+	switch command {
+	case "add":
+		if len(rest) < 1 {
+			return fmt.Errorf("user add: missing name")
+		}
+		passwd := ""
+		if len(rest) > 1 {
+			passwd = rest[1]
+		} else {
+			fmt.Fprint(stdout, "passwd: ")
+			line, err := bufio.NewReader(stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("user add: read passwd failed: %w", err)
+			}
+			passwd = strings.TrimRight(line, "\r\n")
+		}
+		if passwd == "" {
+			return fmt.Errorf("user add: empty passwd")
+		}
+		if err := db.Add(rest[0], passwd); err != nil {
+			return err
+		}
+		return db.Save()
+	case "del":
+		if len(rest) < 1 {
+			return fmt.Errorf("user del: missing name")
+		}
+		if !db.Delete(rest[0]) {
+			return fmt.Errorf("user del: %s not found", rest[0])
+		}
+		return db.Save()
+	case "list":
+		for _, name := range db.List() {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown user command %q", command)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"socks5server-demo/socks5"
+	"strings"
+	"testing"
+)
+
+func TestUserCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.htpasswd")
+	var stdout bytes.Buffer
+	// 密码作为参数，或从标准输入读取
+	if err := userCommand([]string{"add", "-file=" + file, "alice", "123456"}, strings.NewReader(""), &stdout); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := userCommand([]string{"add", "-file=" + file, "bob"}, strings.NewReader("654321\n"), &stdout); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := userCommand([]string{"add", "-file=" + file, "carol"}, strings.NewReader("\n"), &stdout); err == nil {
+		t.Fatalf("want get empty passwd err but got nil")
+	}
+	db, err := socks5.NewUserDB(file)
+	if err != nil {
+		t.Fatalf("NewUserDB error %s", err)
+	}
+	if !db.Check("alice", "123456") || !db.Check("bob", "654321") || db.Check("bob", "123456") {
+		t.Fatalf("want get alice and bob added but got %v", db.List())
+	}
+
+	stdout.Reset()
+	if err := userCommand([]string{"list", "-file=" + file}, strings.NewReader(""), &stdout); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if stdout.String() != "alice\nbob\n" {
+		t.Fatalf("want get alice and bob but got %q", stdout.String())
+	}
+
+	if err := userCommand([]string{"del", "-file=" + file, "alice"}, strings.NewReader(""), &stdout); err != nil {
+		t.Fatalf("want get err == nil but got %s", err)
+	}
+	if err := userCommand([]string{"del", "-file=" + file, "alice"}, strings.NewReader(""), &stdout); err == nil {
+		t.Fatalf("want get not found err but got nil")
+	}
+	stdout.Reset()
+	userCommand([]string{"list", "-file=" + file}, strings.NewReader(""), &stdout)
+	if stdout.String() != "bob\n" {
+		t.Fatalf("want get bob but got %q", stdout.String())
+	}
+
+	if err := userCommand([]string{"rename", "-file=" + file}, strings.NewReader(""), &stdout); err == nil {
+		t.Fatalf("want get unknown command err but got nil")
+	}
+}